@@ -6,6 +6,10 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
@@ -15,95 +19,196 @@ import (
 type cliArgs struct {
 	startingStateFile string
 	genStateFile      bool
+	durationsFile     string
+	temporal          bool
+	calendarFile      string
+	start             string
+	costsFile         string
+	pipelinesFile     string
+	dependenciesFile  string
+	compatibilityFile string
+	schemaMigration   bool
+	canary            bool
+	canaryNodes       string
+	canaryLabels      string
+	strategy          string
+	standby           string
+	compare           bool
+	weightsFiles      string
+	safetyFactor      float64
+	scope             string
+	frozen            string
+	maxSurge          int
+	clusterFailover   bool
+	osPatchLevel      int
+	configVersion     int
+	hotReload         bool
+	rampStep          int
+	drainTimeout      time.Duration
+	forceStop         bool
+	groupBy           string
+	orderBy           string
+	firstNodes        string
 }
 
 func parseArgs() cliArgs {
 	startingStateFile := flag.String("stateFile", "startingState.yaml", "File containing starting state for planner; use -genStateFile to produce an example")
 	genStateFile := flag.Bool("genStateFile", false, "Generate an example stateFile, then exit")
-	flag.Parse()
+	durationsFile := flag.String("durationsFile", "", "File containing action durations for -temporal; defaults are used if omitted")
+	temporal := flag.Bool("temporal", false, "Produce a schedule of overlapping actions with start/end offsets, starting each as early as the plan allows")
+	calendarFile := flag.String("calendarFile", "", "File containing a demand curve and per-cluster maintenance windows; implies -temporal, with absolute timestamps")
+	start := flag.String("start", "", "Rollout start time for -calendarFile, in RFC 3339 format; overrides the file's start, and defaults to now")
+	costsFile := flag.String("costsFile", "", "File containing the cost model to minimise; defaults to minimising the number of actions; -temporal minimises time spent on actions instead")
+	pipelinesFile := flag.String("pipelinesFile", "", "File containing per-role action pipelines; built-in pipelines are used for roles it omits")
+	dependenciesFile := flag.String("dependenciesFile", "", "File listing groups of nodes which must be updated before others")
+	compatibilityFile := flag.String("compatibilityFile", "", "File listing pairs of revisions which mustn't serve traffic together")
+	schemaMigration := flag.Bool("schemaMigration", false, "Expand the schema before any node serves the new revision, and contract it once none run the old one")
+	canary := flag.Bool("canary", false, "Upgrade a canary node first, then pause for verification before continuing; the canary is the first node by name unless -canaryNodes or -canaryLabels is given")
+	canaryNodes := flag.String("canaryNodes", "", "Comma-separated names of canary nodes; implies -canary")
+	canaryLabels := flag.String("canaryLabels", "", "Comma-separated key=value labels picking canary nodes; implies -canary")
+	strategy := flag.String("strategy", "rolling", "Deployment strategy: rolling, or bluegreen to deploy to an idle group and then switch traffic over to it")
+	standby := flag.String("standby", "", "Group to deploy to with -strategy bluegreen; defaults to the first group with no nodes in the pool")
+	weightsFiles := flag.String("weightsFiles", "", "Comma-separated cost model files, each planned as an extra rolling strategy by the compare subcommand")
+	safetyFactor := flag.Float64("safetyFactor", 1, "Multiplier applied to the state file's demand to give the capacity which must stay in the pool")
+	scope := flag.String("scope", "", "Only upgrade the nodes selected, as comma-separated name=, cluster= or other label=value terms; names and clusters may repeat")
+	frozen := flag.String("frozen", "", "Never touch the nodes selected, in the same form as -scope")
+	osPatchLevel := flag.Int("osPatchLevel", 0, "OS patch level every node must reach, in the same drain cycle as its software update; nodes with needsreboot set are rebooted regardless")
+	configVersion := flag.Int("configVersion", 0, "Config version every node must reach; without -hotReload nodes are restarted to load it, in the same drain cycle as their software update")
+	hotReload := flag.Bool("hotReload", false, "The -configVersion change can be hot-reloaded, so nodes load it in place rather than being drained and restarted")
+	rampStep := flag.Int("rampStep", 0, "Percentage of full load-balancer weight nodes rejoin the pool at, and ramp up by until they reach it; 0 adds them at full weight")
+	drainTimeout := flag.Duration("drainTimeout", 0, "How long every drained node waits for its connections to close before its app is stopped; 0 waits only on nodes with activeconnections, for as long as the executor likes")
+	forceStop := flag.Bool("forceStop", false, "Stop apps whose connections were still open when the wait for them timed out (draintimedout); otherwise the planner refuses")
+	maxSurge := flag.Int("maxSurge", 0, "Number of extra nodes, at the new revision, which may be added before old ones are drained; extras are decommissioned before the rollout ends")
+	clusterFailover := flag.Bool("clusterFailover", false, "Allow whole clusters' traffic to be failed over at the global load balancer, instead of draining their nodes one at a time, where that costs less")
+	groupBy := flag.String("groupBy", maintenance.ClusterLabel, "Node label to group by, when deciding which nodes may be down at once")
+	orderBy := flag.String("orderBy", "", "Node label to order nodes within a group by, after their priority; values sort numerically or lexically, unless listed first as label=value1,value2")
+	firstNodes := flag.String("firstNodes", "", "Comma-separated names of nodes to take first within their group, like canaries but without pausing for verification")
+
+	// "plannerdemo compare [flags]" runs every strategy and reports on each
+	var compare bool
+	if len(os.Args) > 1 && os.Args[1] == "compare" {
+		compare = true
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 
 	return cliArgs{
 		startingStateFile: *startingStateFile,
 		genStateFile:      *genStateFile,
+		durationsFile:     *durationsFile,
+		temporal:          *temporal || *calendarFile != "",
+		calendarFile:      *calendarFile,
+		start:             *start,
+		costsFile:         *costsFile,
+		pipelinesFile:     *pipelinesFile,
+		dependenciesFile:  *dependenciesFile,
+		compatibilityFile: *compatibilityFile,
+		schemaMigration:   *schemaMigration,
+		canary:            *canary,
+		canaryNodes:       *canaryNodes,
+		canaryLabels:      *canaryLabels,
+		strategy:          *strategy,
+		standby:           *standby,
+		compare:           compare,
+		weightsFiles:      *weightsFiles,
+		safetyFactor:      *safetyFactor,
+		scope:             *scope,
+		frozen:            *frozen,
+		maxSurge:          *maxSurge,
+		clusterFailover:   *clusterFailover,
+		osPatchLevel:      *osPatchLevel,
+		configVersion:     *configVersion,
+		hotReload:         *hotReload,
+		rampStep:          *rampStep,
+		drainTimeout:      *drainTimeout,
+		forceStop:         *forceStop,
+		groupBy:           *groupBy,
+		orderBy:           *orderBy,
+		firstNodes:        *firstNodes,
 	}
 }
 
 func genStateFile(filename string) error {
-	startingState := maintenance.State{
+	startingState := maintenance.State{Nodes: []maintenance.NodeState{
 		maintenance.NodeState{
-			Name:               "app1-1",
-			Cluster:            1,
-			SoftwareRevision:   1,
-			AppRunning:         true,
-			InLoadbalancerPool: true,
-			CacheWarmed:        true,
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       maintenance.FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
 		},
 		maintenance.NodeState{
-			Name:               "app1-2",
-			Cluster:            1,
-			SoftwareRevision:   1,
-			AppRunning:         true,
-			InLoadbalancerPool: false,
-			CacheWarmed:        true,
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       0,
+			CacheWarmed:      true,
 		},
 		maintenance.NodeState{
-			Name:               "app1-3",
-			Cluster:            1,
-			SoftwareRevision:   1,
-			AppRunning:         false,
-			InLoadbalancerPool: false,
-			CacheWarmed:        false,
+			Name:             "app1-3",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       false,
+			PoolWeight:       0,
+			CacheWarmed:      false,
 		},
 		maintenance.NodeState{
-			Name:               "app1-4",
-			Cluster:            1,
-			SoftwareRevision:   2,
-			AppRunning:         false,
-			InLoadbalancerPool: false,
-			CacheWarmed:        false,
+			Name:             "app1-4",
+			Cluster:          1,
+			SoftwareRevision: 2,
+			AppRunning:       false,
+			PoolWeight:       0,
+			CacheWarmed:      false,
 		},
 		maintenance.NodeState{
-			Name:               "app1-5",
-			Cluster:            1,
-			SoftwareRevision:   2,
-			AppRunning:         true,
-			InLoadbalancerPool: false,
-			CacheWarmed:        false,
+			Name:             "app1-5",
+			Cluster:          1,
+			SoftwareRevision: 2,
+			AppRunning:       true,
+			PoolWeight:       0,
+			CacheWarmed:      false,
 		},
 		maintenance.NodeState{
-			Name:               "app1-6",
-			Cluster:            1,
-			SoftwareRevision:   2,
-			AppRunning:         true,
-			InLoadbalancerPool: false,
-			CacheWarmed:        true,
+			Name:             "app1-6",
+			Cluster:          1,
+			SoftwareRevision: 2,
+			AppRunning:       true,
+			PoolWeight:       0,
+			CacheWarmed:      true,
 		},
 		maintenance.NodeState{
-			Name:               "app1-7",
-			Cluster:            1,
-			SoftwareRevision:   2,
-			AppRunning:         true,
-			InLoadbalancerPool: true,
-			CacheWarmed:        true,
+			Name:             "app1-7",
+			Cluster:          1,
+			SoftwareRevision: 2,
+			AppRunning:       true,
+			PoolWeight:       maintenance.FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
 		},
 
 		maintenance.NodeState{
-			Name:               "app2-1",
-			Cluster:            2,
-			SoftwareRevision:   1,
-			AppRunning:         true,
-			InLoadbalancerPool: true,
-			CacheWarmed:        true,
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       maintenance.FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
 		},
 		maintenance.NodeState{
-			Name:               "app2-2",
-			Cluster:            2,
-			SoftwareRevision:   1,
-			AppRunning:         true,
-			InLoadbalancerPool: true,
-			CacheWarmed:        true,
+			Name:             "app2-2",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       maintenance.FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
 		},
-	}
+	}}
 
 	fd, err := os.OpenFile(filename, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, 0644)
 	if err != nil {
@@ -121,17 +226,204 @@ func genStateFile(filename string) error {
 	return nil
 }
 
-func parseStateFile(filename string) (maintenance.State, error) {
+// parseStateFile accepts either a flat list of nodes, or a Topology of
+// regions containing clusters containing nodes; in the latter case the
+// Topology's policy is returned too.
+func parseStateFile(filename string) (maintenance.State, *maintenance.TopologyPolicy, error) {
 	var startingState maintenance.State
 	inBytes, err := ioutil.ReadFile(filename)
 	if err != nil {
-		return startingState, fmt.Errorf("ioutil.ReadFile(%q): %s", filename, err)
+		return startingState, nil, fmt.Errorf("ioutil.ReadFile(%q): %s", filename, err)
+	}
+
+	var topology maintenance.Topology
+	if yaml.Unmarshal(inBytes, &topology) == nil && len(topology.Regions) > 0 {
+		startingState, err = topology.State()
+		if err != nil {
+			return startingState, nil, fmt.Errorf("topology.State: %s", err)
+		}
+		return startingState, &topology.Policy, nil
 	}
+
 	err = yaml.Unmarshal(inBytes, &startingState)
 	if err != nil {
-		return startingState, fmt.Errorf("yaml.Unmarshal: %s", err)
+		return startingState, nil, fmt.Errorf("yaml.Unmarshal: %s", err)
+	}
+	return startingState, nil, nil
+}
+
+func parseDurationsFile(filename string) (maintenance.Durations, error) {
+	var durations maintenance.Durations
+	inBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return durations, fmt.Errorf("ioutil.ReadFile(%q): %s", filename, err)
+	}
+	err = yaml.Unmarshal(inBytes, &durations)
+	if err != nil {
+		return durations, fmt.Errorf("yaml.Unmarshal: %s", err)
+	}
+	return durations, nil
+}
+
+func parseCostsFile(filename string) (maintenance.CostModel, error) {
+	var costs maintenance.CostModel
+	inBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return costs, fmt.Errorf("ioutil.ReadFile(%q): %s", filename, err)
+	}
+	err = yaml.UnmarshalStrict(inBytes, &costs)
+	if err != nil {
+		return costs, fmt.Errorf("yaml.UnmarshalStrict: %s", err)
+	}
+	err = costs.Validate()
+	if err != nil {
+		return costs, fmt.Errorf("invalid cost model in %q: %s", filename, err)
+	}
+	return costs, nil
+}
+
+func parsePipelinesFile(filename string) (maintenance.Pipelines, error) {
+	var pipelines maintenance.Pipelines
+	inBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return pipelines, fmt.Errorf("ioutil.ReadFile(%q): %s", filename, err)
+	}
+	err = yaml.UnmarshalStrict(inBytes, &pipelines)
+	if err != nil {
+		return pipelines, fmt.Errorf("yaml.UnmarshalStrict: %s", err)
+	}
+	err = pipelines.Validate()
+	if err != nil {
+		return pipelines, fmt.Errorf("invalid pipelines in %q: %s", filename, err)
+	}
+	return pipelines, nil
+}
+
+func parseDependenciesFile(filename string) (maintenance.Dependencies, error) {
+	var dependencies maintenance.Dependencies
+	inBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return dependencies, fmt.Errorf("ioutil.ReadFile(%q): %s", filename, err)
+	}
+	err = yaml.UnmarshalStrict(inBytes, &dependencies)
+	if err != nil {
+		return dependencies, fmt.Errorf("yaml.UnmarshalStrict: %s", err)
+	}
+	err = dependencies.Validate()
+	if err != nil {
+		return dependencies, fmt.Errorf("invalid dependencies in %q: %s", filename, err)
 	}
-	return startingState, nil
+	return dependencies, nil
+}
+
+func parseCompatibilityFile(filename string) (maintenance.CompatibilityMatrix, error) {
+	var matrix maintenance.CompatibilityMatrix
+	inBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return matrix, fmt.Errorf("ioutil.ReadFile(%q): %s", filename, err)
+	}
+	err = yaml.UnmarshalStrict(inBytes, &matrix)
+	if err != nil {
+		return matrix, fmt.Errorf("yaml.UnmarshalStrict: %s", err)
+	}
+	err = matrix.Validate()
+	if err != nil {
+		return matrix, fmt.Errorf("invalid compatibility matrix in %q: %s", filename, err)
+	}
+	return matrix, nil
+}
+
+func parseCalendarFile(filename, start string) (*maintenance.Calendar, error) {
+	var calendar maintenance.Calendar
+	inBytes, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("ioutil.ReadFile(%q): %s", filename, err)
+	}
+	err = yaml.UnmarshalStrict(inBytes, &calendar)
+	if err != nil {
+		return nil, fmt.Errorf("yaml.UnmarshalStrict: %s", err)
+	}
+	err = calendar.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar in %q: %s", filename, err)
+	}
+	if start != "" {
+		calendar.Start, err = time.Parse(time.RFC3339, start)
+		if err != nil {
+			return nil, fmt.Errorf("time.Parse(%q): %s", start, err)
+		}
+	}
+	if calendar.Start.IsZero() {
+		calendar.Start = time.Now().Truncate(time.Minute)
+	}
+	return &calendar, nil
+}
+
+// parseNodeSelector parses terms like "name=app1-1,cluster=3,zone=a": a node
+// is selected by any of the names or clusters, or by having all the other
+// labels.
+func parseNodeSelector(terms string) (maintenance.NodeSelector, error) {
+	var selector maintenance.NodeSelector
+	for _, term := range strings.Split(terms, ",") {
+		kv := strings.SplitN(term, "=", 2)
+		if len(kv) != 2 {
+			return selector, fmt.Errorf("node selector term %q isn't of the form key=value", term)
+		}
+		switch kv[0] {
+		case "name":
+			selector.Names = append(selector.Names, kv[1])
+		case maintenance.ClusterLabel:
+			cluster, err := strconv.Atoi(kv[1])
+			if err != nil {
+				return selector, fmt.Errorf("strconv.Atoi(%q): %s", kv[1], err)
+			}
+			selector.Clusters = append(selector.Clusters, cluster)
+		default:
+			if selector.Labels == nil {
+				selector.Labels = make(map[string]string)
+			}
+			selector.Labels[kv[0]] = kv[1]
+		}
+	}
+	return selector, nil
+}
+
+func parseCanaryPolicy(args cliArgs) (*maintenance.CanaryPolicy, error) {
+	if !args.canary && args.canaryNodes == "" && args.canaryLabels == "" {
+		return nil, nil
+	}
+	policy := &maintenance.CanaryPolicy{}
+	if args.canaryNodes != "" {
+		policy.Nodes = strings.Split(args.canaryNodes, ",")
+	}
+	if args.canaryLabels != "" {
+		policy.Labels = make(map[string]string)
+		for _, pair := range strings.Split(args.canaryLabels, ",") {
+			kv := strings.SplitN(pair, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("canary label %q isn't of the form key=value", pair)
+			}
+			policy.Labels[kv[0]] = kv[1]
+		}
+	}
+	return policy, nil
+}
+
+// parseNodeOrder parses -orderBy terms like "rack" or "rack=b,a", and
+// -firstNodes.
+func parseNodeOrder(args cliArgs) maintenance.NodeOrder {
+	var order maintenance.NodeOrder
+	if args.orderBy != "" {
+		kv := strings.SplitN(args.orderBy, "=", 2)
+		order.Label = kv[0]
+		if len(kv) == 2 {
+			order.LabelValues = strings.Split(kv[1], ",")
+		}
+	}
+	if args.firstNodes != "" {
+		order.Canaries = &maintenance.CanaryPolicy{Nodes: strings.Split(args.firstNodes, ",")}
+	}
+	return order
 }
 
 func main() {
@@ -147,17 +439,205 @@ func main() {
 		return
 	}
 
-	startingState, err := parseStateFile(args.startingStateFile)
+	startingState, topologyPolicy, err := parseStateFile(args.startingStateFile)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	mp := &maintenance.Planner{}
-	plan := mp.PlanActionsForTargetRevision(startingState, 2)
-	if len(plan) == 0 {
+	mp := &maintenance.Planner{
+		GroupBy:         args.groupBy,
+		SchemaMigration: args.schemaMigration,
+		SafetyFactor:    args.safetyFactor,
+		OSPatchLevel:    args.osPatchLevel,
+		Config:          maintenance.ConfigChange{Version: args.configVersion, HotReload: args.hotReload},
+		RampStep:        args.rampStep,
+		Drain:           maintenance.DrainPolicy{Timeout: args.drainTimeout, ForceStop: args.forceStop},
+		ClusterFailover: args.clusterFailover,
+		Order:           parseNodeOrder(args),
+		Topology:        topologyPolicy,
+	}
+	if args.durationsFile != "" {
+		mp.Durations, err = parseDurationsFile(args.durationsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.costsFile != "" {
+		mp.Costs, err = parseCostsFile(args.costsFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.pipelinesFile != "" {
+		mp.Pipelines, err = parsePipelinesFile(args.pipelinesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.dependenciesFile != "" {
+		mp.Dependencies, err = parseDependenciesFile(args.dependenciesFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.compatibilityFile != "" {
+		mp.Compatibility, err = parseCompatibilityFile(args.compatibilityFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if args.scope != "" {
+		scope, err := parseNodeSelector(args.scope)
+		if err != nil {
+			log.Fatal(err)
+		}
+		mp.Scope = &scope
+	}
+	if args.frozen != "" {
+		mp.Frozen, err = parseNodeSelector(args.frozen)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if args.maxSurge > 0 {
+		mp.Surge = &maintenance.SurgePolicy{MaxSurge: args.maxSurge}
+	}
+	if args.calendarFile != "" {
+		mp.Calendar, err = parseCalendarFile(args.calendarFile, args.start)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	mp.Canary, err = parseCanaryPolicy(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	bg := &maintenance.BlueGreenPlanner{
+		Label:        args.groupBy,
+		Standby:      args.standby,
+		Pipelines:    mp.Pipelines,
+		Durations:    mp.Durations,
+		OSPatchLevel: mp.OSPatchLevel,
+		Config:       mp.Config,
+		Scope:        mp.Scope,
+		Frozen:       mp.Frozen,
+		SafetyFactor: mp.SafetyFactor,
+	}
+
+	if args.compare {
+		err = compareStrategies(args, startingState, mp, bg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var strategy maintenance.Strategy
+	switch args.strategy {
+	case "rolling":
+		strategy = mp
+	case "bluegreen":
+		strategy = bg
+	default:
+		log.Fatalf("Unknown strategy %q\n", args.strategy)
+	}
+
+	if args.temporal {
+		schedule := strategy.PlanScheduleForTargetRevision(startingState, 2)
+		if len(schedule) == 0 {
+			log.Println("Empty plan returned.")
+		}
+		for _, sa := range schedule {
+			if mp.Calendar != nil {
+				log.Printf("%s..%s %s\n", mp.Calendar.Timestamp(sa.Start).Format(time.RFC3339), mp.Calendar.Timestamp(sa.End).Format(time.RFC3339), sa.Action)
+				continue
+			}
+			log.Printf("+%s..+%s %s\n", sa.Start, sa.End, sa.Action)
+		}
+		log.Printf("Makespan: %s\n", schedule.Makespan())
+		return
+	}
+
+	stages := strategy.PlanStagesForTargetRevision(startingState, 2)
+	if len(stages) == 0 {
 		log.Println("Empty plan returned.")
 	}
-	for _, action := range plan {
-		log.Println(action)
+	for i, stage := range stages {
+		log.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			log.Printf("    %s\n", action)
+		}
+	}
+}
+
+// compareStrategies plans with the rolling, canary and blue/green strategies,
+// the rolling one also with Dijkstra's algorithm and with each cost model in
+// -weightsFiles, and prints a table summarising each plan.
+func compareStrategies(args cliArgs, startingState maintenance.State, mp *maintenance.Planner, bg *maintenance.BlueGreenPlanner) error {
+	// each plan is costed with its own planner's cost model; blue/green has
+	// none, so it's costed like the rolling plan
+	type namedStrategy struct {
+		name     string
+		strategy maintenance.Strategy
+		costs    maintenance.CostModel
+	}
+	// planners are copied before any of them is used, so each keeps its own
+	// statistics
+	dijkstra := *mp
+	dijkstra.Algorithm = maintenance.AlgorithmDijkstra
+	canary := *mp
+	if canary.Canary == nil {
+		canary.Canary = &maintenance.CanaryPolicy{}
+	}
+	strategies := []namedStrategy{
+		{"rolling", mp, mp.Costs},
+		{"rolling (dijkstra)", &dijkstra, dijkstra.Costs},
+		{"canary", &canary, canary.Costs},
+		{"bluegreen", bg, mp.Costs},
+	}
+	if args.weightsFiles != "" {
+		for _, filename := range strings.Split(args.weightsFiles, ",") {
+			weighted := *mp
+			var err error
+			weighted.Costs, err = parseCostsFile(filename)
+			if err != nil {
+				return err
+			}
+			strategies = append(strategies, namedStrategy{fmt.Sprintf("rolling (%s)", filename), &weighted, weighted.Costs})
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STRATEGY\tACTIONS\tCOST\tSTAGES\tEST. DURATION\tMIN IN POOL\tMIN CAPACITY\tMIXED-REVISION TIME\tEXPANSIONS\tPLAN TIME")
+	for _, s := range strategies {
+		startTime := time.Now()
+		stages := s.strategy.PlanStagesForTargetRevision(startingState, 2)
+		planTime := time.Since(startTime)
+		if len(stages) == 0 {
+			fmt.Fprintf(w, "%s\tno plan\t\t\t\t\t\t\t%d\t%s\n", s.name, s.strategy.Expansions(), planTime.Round(time.Millisecond))
+			continue
+		}
+		var plan []maintenance.MaintenanceAction
+		for _, stage := range stages {
+			plan = append(plan, stage...)
+		}
+		summary := maintenance.SummarisePlan(startingState, stages, mp.Durations)
+		fmt.Fprintf(w, "%s\t%d\t%g\t%d\t%s\t%d/%d\t%g\t%s\t%d\t%s\n",
+			s.name,
+			summary.Actions,
+			maintenance.PlanCost(s.costs, plan),
+			summary.Stages,
+			summary.Duration,
+			summary.MinInPool,
+			len(startingState.Nodes),
+			summary.MinInPoolCapacity,
+			summary.MixedRevisionTime,
+			s.strategy.Expansions(),
+			planTime.Round(time.Millisecond),
+		)
 	}
+	return w.Flush()
 }
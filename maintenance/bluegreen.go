@@ -0,0 +1,297 @@
+package maintenance
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// BlueGreenPlanner plans blue/green deployments: an idle ("green") group of
+// nodes is brought to the target revision and warmed while the rest of the
+// fleet ("blue") keeps serving, then the green nodes are all added to the
+// pool in one stage, and finally the blue nodes are drained. The blue nodes
+// are left running the old revision, ready to switch back to, and become the
+// standby group for the next deployment.
+//
+// Plans are made of the same actions as the rolling Planner's, but end with
+// the blue nodes out of the pool rather than every node upgraded.
+type BlueGreenPlanner struct {
+	// Label groups nodes; defaults to ClusterLabel.
+	Label string
+	// Standby is the value of Label for the green group. If empty, the
+	// lowest sorted group with no nodes in the pool is used.
+	Standby string
+	// Pipelines decide which actions each green node goes through.
+	Pipelines Pipelines
+	// OSPatchLevel is the operating system patch level the green nodes must
+	// reach; they're patched and rebooted along with their software update.
+	OSPatchLevel int
+	// Config is the configuration change the green nodes must take; running
+	// ones load it in place if it's hot-reloadable.
+	Config ConfigChange
+	// Scope, if set, limits the deployment to the nodes it selects; others
+	// are left as they are.
+	Scope *NodeSelector
+	// Frozen nodes are never touched, though blue ones stay in the pool.
+	Frozen NodeSelector
+	// SafetyFactor multiplies the State's Demand to give the capacity which
+	// must stay in the pool once the blue nodes are drained. Defaults to 1.
+	SafetyFactor float64
+	// Durations are used by PlanScheduleForTargetRevision; defaults to
+	// DefaultDurations.
+	Durations Durations
+}
+
+func (bg *BlueGreenPlanner) goal(targetSoftwareRevision int) Goal {
+	return Goal{
+		TargetRevision: targetSoftwareRevision,
+		OSPatchLevel:   bg.OSPatchLevel,
+		Config:         bg.Config,
+		Pipelines:      bg.Pipelines,
+	}
+}
+
+func (bg *BlueGreenPlanner) filter() NodeFilter {
+	return NodeFilter{Scope: bg.Scope, Frozen: bg.Frozen}
+}
+
+func (bg *BlueGreenPlanner) label() string {
+	if bg.Label == "" {
+		return ClusterLabel
+	}
+	return bg.Label
+}
+
+// standbyGroup returns the value of Label for the green group, or an error
+// if there's no suitable one.
+func (bg *BlueGreenPlanner) standbyGroup(state State) (string, error) {
+	label := bg.label()
+	serving := make(map[string]bool)
+	var groups []string
+	for _, nodeState := range state.Nodes {
+		groupValue := nodeState.Label(label)
+		if _, seen := serving[groupValue]; !seen {
+			groups = append(groups, groupValue)
+			serving[groupValue] = false
+		}
+		if nodeState.inPool() {
+			serving[groupValue] = true
+		}
+	}
+	if bg.Standby != "" {
+		found, seen := serving[bg.Standby]
+		if !seen {
+			return "", fmt.Errorf("no nodes in %s %q", label, bg.Standby)
+		}
+		if found {
+			return "", fmt.Errorf("%s %q is serving traffic, so can't be the standby", label, bg.Standby)
+		}
+		return bg.Standby, nil
+	}
+	sortLabelValues(groups)
+	for _, groupValue := range groups {
+		if !serving[groupValue] {
+			return groupValue, nil
+		}
+	}
+	return "", fmt.Errorf("every %s is serving traffic; there's no idle one to deploy to", label)
+}
+
+func (bg *BlueGreenPlanner) PlanActionsForTargetRevision(startingState State, targetSoftwareRevision int) []MaintenanceAction {
+	green, err := bg.standbyGroup(startingState)
+	if err != nil {
+		log.Printf("Refusing to plan blue/green deployment: %s\n", err)
+		return nil
+	}
+	err = bg.Pipelines.Validate()
+	if err != nil {
+		log.Printf("Refusing to plan with invalid pipelines: %s\n", err)
+		return nil
+	}
+	goal := bg.goal(targetSoftwareRevision)
+	filter := bg.filter()
+	var blocked []string
+	for _, name := range BlockedNodes(startingState, goal) {
+		if filter.Allows(startingState.Nodes[startingState.indexOfNode(name)]) {
+			blocked = append(blocked, name)
+		}
+	}
+	if len(blocked) > 0 {
+		log.Printf("Refusing to plan; nodes blocked by failed health checks: %s\n", strings.Join(blocked, ", "))
+		return nil
+	}
+
+	label := bg.label()
+	var greenNodes, blueNodes []string
+	for _, nodeState := range startingState.Nodes {
+		if !filter.Allows(nodeState) {
+			continue
+		}
+		if nodeState.Label(label) == green {
+			greenNodes = append(greenNodes, nodeState.Name)
+		} else if nodeState.inPool() {
+			blueNodes = append(blueNodes, nodeState.Name)
+		}
+	}
+	if len(greenNodes) == 0 {
+		log.Printf("Refusing to plan; every node in %s %q is out of scope or frozen\n", label, green)
+		return nil
+	}
+
+	prototypes := map[ActionKind]MaintenanceAction{
+		KindStopApp:                &StopAppAction{Goal: goal, Filter: filter},
+		KindUpdateSoftwareRevision: &UpdateSoftwareRevisionAction{Goal: goal, Filter: filter},
+		KindUpdateConfig:           &UpdateConfigAction{Goal: goal, Filter: filter},
+		KindReloadConfig:           &ReloadConfigAction{Goal: goal, Filter: filter},
+		KindPatchOS:                &PatchOSAction{Goal: goal, Filter: filter},
+		KindRebootNode:             &RebootNodeAction{Goal: goal, Filter: filter},
+		KindStartApp:               &StartAppAction{Goal: goal, Filter: filter},
+		KindHealthCheck:            &HealthCheckAction{Goal: goal, Filter: filter},
+		KindCatchUpReplication:     &CatchUpReplicationAction{Goal: goal, Filter: filter},
+		KindWarmCache:              &WarmCacheAction{Goal: goal, Filter: filter},
+		KindAddNodeToPool:          &AddNodeToPoolAction{Goal: goal, Filter: filter},
+		KindDrainNodeFromPool:      &DrainNodeFromPoolAction{Goal: goal, Filter: filter},
+	}
+
+	constraints := bg.constraints(startingState, targetSoftwareRevision)
+	var plan []MaintenanceAction
+	state := startingState
+	take := func(kind ActionKind, name string) error {
+		for _, action := range prototypes[kind].CloneForValidTargets(state) {
+			if action.NodeName() == name {
+				violations := checkConstraints(constraints, state, action)
+				if len(violations) > 0 {
+					return violations[0].Err
+				}
+				plan = append(plan, action)
+				state = action.FinalState()
+				return nil
+			}
+		}
+		return fmt.Errorf("no %s action is possible for node %s", kind, name)
+	}
+
+	// bring the green nodes up to the target revision, keeping them in step
+	// with each other so that each step can run as a single stage
+	for {
+		lowestStep := -1
+		for _, name := range greenNodes {
+			nodeState := state.Nodes[state.indexOfNode(name)]
+			kind := nextKindForNode(nodeState, goal)
+			if kind == "" || kind == KindAddNodeToPool {
+				continue
+			}
+			step := stepNumberForNode(nodeState, goal)
+			if lowestStep < 0 || step < lowestStep {
+				lowestStep = step
+			}
+		}
+		if lowestStep < 0 {
+			break
+		}
+		planned := len(plan)
+		for _, name := range greenNodes {
+			nodeState := state.Nodes[state.indexOfNode(name)]
+			if stepNumberForNode(nodeState, goal) != lowestStep {
+				continue
+			}
+			kind := nextKindForNode(nodeState, goal)
+			if kind == KindUpdateConfig {
+				kind = configKindForNode(nodeState, goal.Config)
+			}
+			err = take(kind, name)
+			if err != nil {
+				log.Printf("Unable to bring %s %q to revision %d: %s\n", label, green, targetSoftwareRevision, err)
+				return nil
+			}
+		}
+		if len(plan) == planned {
+			log.Printf("Unable to bring %s %q to revision %d\n", label, green, targetSoftwareRevision)
+			return nil
+		}
+	}
+	// switch traffic over...
+	for _, name := range greenNodes {
+		err = take(KindAddNodeToPool, name)
+		if err != nil {
+			log.Printf("Unable to switch traffic over to %s %q: %s\n", label, green, err)
+			return nil
+		}
+	}
+	// ...then take the blue nodes out of service
+	for _, name := range blueNodes {
+		err = take(KindDrainNodeFromPool, name)
+		if err != nil {
+			log.Printf("Unable to take the old nodes out of service: %s\n", err)
+			return nil
+		}
+	}
+	return plan
+}
+
+func (bg *BlueGreenPlanner) PlanStagesForTargetRevision(startingState State, targetSoftwareRevision int) []Stage {
+	plan := bg.PlanActionsForTargetRevision(startingState, targetSoftwareRevision)
+	return stagePlan(bg.constraints(startingState, targetSoftwareRevision), startingState, plan)
+}
+
+func (bg *BlueGreenPlanner) PlanScheduleForTargetRevision(startingState State, targetSoftwareRevision int) Schedule {
+	plan := bg.PlanActionsForTargetRevision(startingState, targetSoftwareRevision)
+	if plan == nil {
+		return nil
+	}
+	durations := bg.Durations
+	if durations.isEmpty() {
+		durations = DefaultDurations()
+	}
+	schedule, err := scheduleActions(bg.constraints(startingState, targetSoftwareRevision), startingState, plan, durations, nil)
+	if err != nil {
+		log.Printf("Refusing to plan; unable to schedule: %s\n", err)
+		return nil
+	}
+	return schedule
+}
+
+// Expansions is always zero, since blue/green plans are built directly
+// rather than searched for.
+func (bg *BlueGreenPlanner) Expansions() int {
+	return 0
+}
+
+// constraints returns what's needed to keep the blue nodes serving until
+// the green ones have taken over, and to keep enough capacity in the pool
+// for the State's Demand, when planning, staging or scheduling.
+func (bg *BlueGreenPlanner) constraints(startingState State, targetSoftwareRevision int) []Constraint {
+	green, err := bg.standbyGroup(startingState)
+	if err != nil {
+		return nil
+	}
+	return []Constraint{
+		&BlueGreenSwitchConstraint{Label: bg.label(), Green: green, Filter: bg.filter()},
+		&CapacityConstraint{Goal: bg.goal(targetSoftwareRevision), SafetyFactor: bg.SafetyFactor, Filter: bg.filter()},
+	}
+}
+
+// BlueGreenSwitchConstraint refuses to drain nodes outside the Green group
+// until every node in it is in the load-balancer pool, bar those the Filter
+// leaves as they are.
+type BlueGreenSwitchConstraint struct {
+	Label  string
+	Green  string
+	Filter NodeFilter
+}
+
+func (bgsc *BlueGreenSwitchConstraint) Name() string {
+	return "blue-green-switch"
+}
+
+func (bgsc *BlueGreenSwitchConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	if _, ok := action.(*DrainNodeFromPoolAction); !ok {
+		return nil
+	}
+	for _, nodeState := range state.Nodes {
+		if nodeState.Label(bgsc.Label) == bgsc.Green && !nodeState.inPool() && bgsc.Filter.Allows(nodeState) {
+			return fmt.Errorf("node %s in %s %q isn't serving yet", nodeState.Name, bgsc.Label, bgsc.Green)
+		}
+	}
+	return nil
+}
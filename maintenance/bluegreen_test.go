@@ -0,0 +1,329 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestBlueGreenPlanner_standbyGroup(t *testing.T) {
+	t.Parallel()
+
+	state := State{Nodes: []NodeState{
+		{Name: "app1-1", Cluster: 1, PoolWeight: FullWeight},
+		{Name: "app2-1", Cluster: 2},
+		{Name: "app3-1", Cluster: 3},
+	}}
+
+	testCases := map[string]struct {
+		standby     string
+		expected    string
+		expectError bool
+	}{
+		"first idle group": {
+			expected: "2",
+		},
+		"explicit": {
+			standby:  "3",
+			expected: "3",
+		},
+		"explicit but serving": {
+			standby:     "1",
+			expectError: true,
+		},
+		"explicit but empty": {
+			standby:     "4",
+			expectError: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			bg := &BlueGreenPlanner{Standby: tc.standby}
+			actual, err := bg.standbyGroup(state)
+			if tc.expectError && err == nil {
+				t.Errorf("expected an error, got %q", actual)
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestBlueGreenPlanner_noIdleGroup(t *testing.T) {
+	state := State{Nodes: []NodeState{
+		{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight},
+		{Name: "app2-1", Cluster: 2, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	plan := (&BlueGreenPlanner{}).PlanActionsForTargetRevision(state, 2)
+	log.SetOutput(os.Stdout)
+	if plan != nil {
+		t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+	}
+}
+
+func TestBlueGreenPlanner_greenUnavailable(t *testing.T) {
+	state := State{
+		Nodes: []NodeState{
+			{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight},
+			{Name: "app2-1", Cluster: 2, SoftwareRevision: 1},
+		},
+		// the green nodes can't rejoin the pool until traffic is restored
+		Traffic: TrafficState{FailedOver: []int{2}},
+	}
+
+	log.SetOutput(ioutil.Discard)
+	plan := (&BlueGreenPlanner{}).PlanActionsForTargetRevision(state, 2)
+	log.SetOutput(os.Stdout)
+	if plan != nil {
+		t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+	}
+}
+
+func TestBlueGreenPlanner_capacity(t *testing.T) {
+	t.Parallel()
+
+	blue := func(name string) NodeState {
+		return NodeState{Name: name, Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true, Capacity: 100}
+	}
+	green := func(name string, capacity float64) NodeState {
+		return NodeState{Name: name, Cluster: 2, SoftwareRevision: 1, Capacity: capacity}
+	}
+
+	testCases := map[string]struct {
+		greenCapacity float64
+		safetyFactor  float64
+		expectNil     bool
+	}{
+		"green can carry the demand": {
+			greenCapacity: 100,
+		},
+		"green too small": {
+			greenCapacity: 5,
+			expectNil:     true,
+		},
+		"green too small given the safety factor": {
+			greenCapacity: 80,
+			safetyFactor:  1.5,
+			expectNil:     true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			state := State{
+				Nodes:  []NodeState{blue("app1-1"), blue("app1-2"), green("app2-1", tc.greenCapacity), green("app2-2", tc.greenCapacity)},
+				Demand: Demand{Global: 150},
+			}
+			bg := &BlueGreenPlanner{SafetyFactor: tc.safetyFactor}
+			plan := bg.PlanActionsForTargetRevision(state, 2)
+			if tc.expectNil && plan != nil {
+				t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+			}
+			if !tc.expectNil && len(plan) == 0 {
+				t.Errorf("expected a plan, got none")
+			}
+		})
+	}
+}
+
+func TestBlueGreenPlanner_filter(t *testing.T) {
+	t.Parallel()
+
+	state := State{Nodes: []NodeState{
+		{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true},
+		{Name: "app1-2", Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true},
+		{Name: "app2-1", Cluster: 2, SoftwareRevision: 1},
+		{Name: "app2-2", Cluster: 2, SoftwareRevision: 1},
+	}}
+
+	testCases := map[string]struct {
+		planner   BlueGreenPlanner
+		untouched []string
+		expectNil bool
+	}{
+		"frozen green node": {
+			planner:   BlueGreenPlanner{Frozen: NodeSelector{Names: []string{"app2-2"}}},
+			untouched: []string{"app2-2"},
+		},
+		"frozen blue node": {
+			planner:   BlueGreenPlanner{Frozen: NodeSelector{Names: []string{"app1-1"}}},
+			untouched: []string{"app1-1"},
+		},
+		"scoped to one node of each": {
+			planner:   BlueGreenPlanner{Scope: &NodeSelector{Names: []string{"app1-2", "app2-1"}}},
+			untouched: []string{"app1-1", "app2-2"},
+		},
+		"every green node out of scope": {
+			planner:   BlueGreenPlanner{Scope: &NodeSelector{Clusters: []int{1}}},
+			expectNil: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			plan := tc.planner.PlanActionsForTargetRevision(state, 2)
+			if tc.expectNil {
+				if plan != nil {
+					t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+				}
+				return
+			}
+			if len(plan) == 0 {
+				t.Fatal("expected a plan, got none")
+			}
+			constraints := tc.planner.constraints(state, 2)
+			current := state
+			for _, action := range plan {
+				for _, name := range tc.untouched {
+					if action.NodeName() == name {
+						t.Errorf("expected %s to be left alone, got %q", name, action)
+					}
+				}
+				for _, violation := range checkConstraints(constraints, current, action) {
+					t.Errorf("expected %q to be allowed, got %s", action, violation.Err)
+				}
+				current = action.FinalState()
+			}
+		})
+	}
+}
+
+func TestBlueGreenPlanner_goal(t *testing.T) {
+	t.Parallel()
+
+	green := func(revision int, running bool) NodeState {
+		return NodeState{Name: "app2-1", Cluster: 2, SoftwareRevision: revision, OSPatchLevel: 1, AppRunning: running}
+	}
+
+	testCases := map[string]struct {
+		planner  BlueGreenPlanner
+		green    NodeState
+		expected []ActionKind
+	}{
+		"revision": {
+			green:    green(1, false),
+			expected: []ActionKind{KindUpdateSoftwareRevision, KindStartApp, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+		"OS patch": {
+			planner:  BlueGreenPlanner{OSPatchLevel: 2},
+			green:    green(1, false),
+			expected: []ActionKind{KindUpdateSoftwareRevision, KindPatchOS, KindRebootNode, KindStartApp, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+		"config": {
+			planner:  BlueGreenPlanner{Config: ConfigChange{Version: 3}},
+			green:    green(1, false),
+			expected: []ActionKind{KindUpdateSoftwareRevision, KindUpdateConfig, KindStartApp, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+		"hot-reloaded config": {
+			planner:  BlueGreenPlanner{Config: ConfigChange{Version: 3, HotReload: true}},
+			green:    green(1, false),
+			expected: []ActionKind{KindUpdateSoftwareRevision, KindUpdateConfig, KindStartApp, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+		"hot-reloaded config, already at the revision": {
+			planner:  BlueGreenPlanner{Config: ConfigChange{Version: 3, HotReload: true}},
+			green:    green(2, true),
+			expected: []ActionKind{KindReloadConfig, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			state := State{Nodes: []NodeState{
+				{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, OSPatchLevel: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true},
+				tc.green,
+			}}
+			var actual []ActionKind
+			for _, action := range tc.planner.PlanActionsForTargetRevision(state, 2) {
+				if action.NodeName() == "app2-1" {
+					actual = append(actual, action.Kind())
+				}
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func ExampleBlueGreenPlanner() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+		},
+		NodeState{
+			Name:             "app2-2",
+			Cluster:          2,
+			SoftwareRevision: 1,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	bg := &BlueGreenPlanner{}
+	stages := bg.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Stop app: app2-1
+	// Stage 2:
+	//     Update software: app2-1
+	//     Update software: app2-2
+	// Stage 3:
+	//     Start app: app2-1
+	//     Start app: app2-2
+	// Stage 4:
+	//     Health check: app2-1
+	//     Health check: app2-2
+	// Stage 5:
+	//     Warm cache: app2-1
+	//     Warm cache: app2-2
+	// Stage 6:
+	//     Add node to pool: app2-1
+	//     Add node to pool: app2-2
+	// Stage 7:
+	//     Drain node from pool: app1-1
+	//     Drain node from pool: app1-2
+}
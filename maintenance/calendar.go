@@ -0,0 +1,429 @@
+package maintenance
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// A Calendar places a rollout in time: when it starts, how demand is
+// forecast to change through the day, and when each cluster may be touched.
+type Calendar struct {
+	// Start is when the rollout begins; offsets in a Schedule are relative
+	// to it, and times of day are in its location.
+	Start time.Time `yaml:"start"`
+	// DemandCurve forecasts demand through the day. If set, it replaces the
+	// State's Demand when scheduling drains.
+	DemandCurve DemandCurve `yaml:"demandcurve"`
+	// Windows lists, by cluster, the times of day when that cluster's nodes
+	// may be under maintenance. Clusters without any may be touched at any
+	// time.
+	Windows map[int][]Window `yaml:"windows"`
+}
+
+// A DemandPoint sets the demand from its time of day until the next point's.
+type DemandPoint struct {
+	// At is the time of day, as an offset from midnight.
+	At     time.Duration `yaml:"at"`
+	Demand Demand        `yaml:"demand"`
+}
+
+// A DemandCurve's points are sorted by time of day; the last point's demand
+// carries on past midnight until the first point's time.
+type DemandCurve []DemandPoint
+
+// A Window is a period each day, given as offsets from midnight. An End
+// before Start means the window spans midnight.
+type Window struct {
+	Start time.Duration `yaml:"start"`
+	End   time.Duration `yaml:"end"`
+}
+
+// Validate checks that the demand curve is sorted and every time of day lies
+// within a day.
+func (c Calendar) Validate() error {
+	for i, point := range c.DemandCurve {
+		if point.At < 0 || point.At >= day {
+			return fmt.Errorf("demand curve point %d: time of day %s isn't within a day", i, point.At)
+		}
+		if i > 0 && point.At <= c.DemandCurve[i-1].At {
+			return fmt.Errorf("demand curve point %d: time of day %s doesn't follow %s", i, point.At, c.DemandCurve[i-1].At)
+		}
+	}
+	for cluster, windows := range c.Windows {
+		for _, window := range windows {
+			if window.Start < 0 || window.Start >= day || window.End < 0 || window.End >= day {
+				return fmt.Errorf("cluster %d: window %s-%s isn't within a day", cluster, window.Start, window.End)
+			}
+			if window.Start == window.End {
+				return fmt.Errorf("cluster %d: window %s-%s is empty", cluster, window.Start, window.End)
+			}
+		}
+	}
+	return nil
+}
+
+// Timestamp returns the absolute time of an offset into the rollout.
+func (c Calendar) Timestamp(offset time.Duration) time.Time {
+	return c.Start.Add(offset)
+}
+
+// timeOfDay returns the time of day at an offset into the rollout.
+func (c Calendar) timeOfDay(offset time.Duration) time.Duration {
+	t := c.Timestamp(offset)
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return t.Sub(midnight)
+}
+
+// demandAt returns the demand forecast at an offset into the rollout.
+func (c Calendar) demandAt(offset time.Duration) Demand {
+	if len(c.DemandCurve) == 0 {
+		return Demand{}
+	}
+	tod := c.timeOfDay(offset)
+	demand := c.DemandCurve[len(c.DemandCurve)-1].Demand
+	for _, point := range c.DemandCurve {
+		if point.At > tod {
+			break
+		}
+		demand = point.Demand
+	}
+	return demand
+}
+
+// nextDemandChange returns the offset of the first point on the demand curve
+// after the given offset, or math.MaxInt64 if there's no curve.
+func (c Calendar) nextDemandChange(offset time.Duration) time.Duration {
+	if len(c.DemandCurve) == 0 {
+		return math.MaxInt64
+	}
+	tod := c.timeOfDay(offset)
+	for _, point := range c.DemandCurve {
+		if point.At > tod {
+			return offset + point.At - tod
+		}
+	}
+	return offset + day - tod + c.DemandCurve[0].At
+}
+
+// fitInWindow returns the earliest offset, no sooner than the given one, at
+// which something lasting length fits entirely within one of the cluster's
+// windows. If none is long enough, it returns the given offset and false.
+func (c Calendar) fitInWindow(cluster int, offset, length time.Duration) (time.Duration, bool) {
+	windows, found := c.Windows[cluster]
+	if !found {
+		return offset, true
+	}
+	midnight := offset - c.timeOfDay(offset)
+	best := time.Duration(math.MaxInt64)
+	// yesterday's windows may run on into today
+	for d := -1; d <= 1; d++ {
+		for _, window := range windows {
+			windowLength := window.End - window.Start
+			if windowLength < 0 {
+				windowLength += day
+			}
+			windowStart := midnight + time.Duration(d)*day + window.Start
+			windowEnd := windowStart + windowLength
+			start := windowStart
+			if offset > start {
+				start = offset
+			}
+			if start+length <= windowEnd && start < best {
+				best = start
+			}
+		}
+	}
+	if best == math.MaxInt64 {
+		return offset, false
+	}
+	return best, true
+}
+
+// placer returns a function which delays an action in a schedule being
+// built until its cluster's window allows it and, for drains, until the
+// forecast demand can be served without the node for as long as it's
+// expected to be out of the pool. Cluster failovers likewise wait until the
+// rest of the pool can serve the forecast demand for as long as the cluster
+// is expected to be failed over. Actions earlier in the plan must already
+// have been placed.
+//
+// The function returns an error if no window is long enough for the action,
+// or if the forecast demand never allows it.
+func (c Calendar) placer(startingState State, plan []MaintenanceAction, durations Durations, safetyFactor float64) func(schedule Schedule, i int, earliest time.Duration) (time.Duration, error) {
+	safetyFactor = safetyFactorOrDefault(safetyFactor)
+
+	// outFor estimates how long a node stays out of the pool from action i:
+	// until it's back at full weight, once its AddNodeToPoolAction and any
+	// RampWeightActions complete.
+	outFor := func(i int) time.Duration {
+		var out time.Duration
+		var added bool
+		for _, action := range plan[i:] {
+			if action.NodeName() != plan[i].NodeName() {
+				continue
+			}
+			_, isRamp := action.(*RampWeightAction)
+			if added && !isRamp {
+				break
+			}
+			out += durations.For(action)
+			if _, ok := action.(*AddNodeToPoolAction); ok {
+				added = true
+			}
+		}
+		return out
+	}
+
+	// failedOverFor estimates how long a cluster's traffic stays failed over
+	// from action i: until it's restored, after the longest-running of its
+	// nodes' actions in between.
+	failedOverFor := func(i int) time.Duration {
+		cluster := plan[i].(clusterAction).targetCluster()
+		byNode := make(map[string]time.Duration)
+		var longest time.Duration
+		for _, action := range plan[i+1:] {
+			if rcta, ok := action.(*RestoreClusterTrafficAction); ok && rcta.cluster == cluster {
+				longest += durations.For(action)
+				break
+			}
+			finalState := action.FinalState()
+			if n := finalState.indexOfNode(action.NodeName()); n >= 0 && finalState.Nodes[n].Cluster == cluster {
+				byNode[action.NodeName()] += durations.For(action)
+				if byNode[action.NodeName()] > longest {
+					longest = byNode[action.NodeName()]
+				}
+			}
+		}
+		return durations.For(plan[i]) + longest
+	}
+
+	return func(schedule Schedule, i int, earliest time.Duration) (time.Duration, error) {
+		action := plan[i]
+		length := durations.For(action)
+		var cluster int
+		// the nodes the action takes out of the pool, if the forecast demand
+		// must be served without them
+		var without []NodeState
+		var failingOver bool
+		switch a := action.(type) {
+		case *FailoverClusterTrafficAction:
+			cluster = a.cluster
+			length = failedOverFor(i)
+			failingOver = true
+			for _, name := range a.drained {
+				if n := startingState.indexOfNode(name); n >= 0 {
+					without = append(without, startingState.Nodes[n])
+				}
+			}
+		case *RestoreClusterTrafficAction:
+			cluster = a.cluster
+		default:
+			n := startingState.indexOfNode(action.NodeName())
+			if n < 0 {
+				return earliest, nil
+			}
+			nodeState := startingState.Nodes[n]
+			cluster = nodeState.Cluster
+			if _, isDrain := action.(*DrainNodeFromPoolAction); isDrain {
+				length = outFor(i)
+				if nodeState.Capacity > 0 {
+					without = []NodeState{nodeState}
+				}
+			}
+		}
+
+		outOfPool := c.outOfPool(startingState, plan, i, schedule, outFor)
+		failedOver := c.failedOver(plan, i, schedule)
+
+		// once every other node is back and every cluster restored, the
+		// windows and demand curve repeat daily, so if a day's worth of
+		// start times won't do then none will
+		horizon := earliest
+		for _, interval := range outOfPool {
+			if interval[1] != math.MaxInt64 && interval[1] > horizon {
+				horizon = interval[1]
+			}
+		}
+		for _, interval := range failedOver {
+			if interval[1] != math.MaxInt64 && interval[1] > horizon {
+				horizon = interval[1]
+			}
+		}
+		horizon += day
+
+		start := earliest
+		for {
+			fitted, ok := c.fitInWindow(cluster, start, length)
+			if !ok {
+				return 0, fmt.Errorf("no window for cluster %d is long enough for %s, which takes %s", cluster, action, length)
+			}
+			start = fitted
+			if len(without) == 0 || len(c.DemandCurve) == 0 {
+				return start, nil
+			}
+			if failingOver {
+				failedOver[cluster] = [2]time.Duration{start, start + length}
+			}
+			short, retryAt := c.shortOfCapacity(startingState, without, outOfPool, failedOver, start, start+length, safetyFactor)
+			if !short {
+				return start, nil
+			}
+			if retryAt == math.MaxInt64 || retryAt > horizon {
+				return 0, fmt.Errorf("the forecast demand never leaves room for %s, which takes %s", action, length)
+			}
+			start = retryAt
+		}
+	}
+}
+
+// outOfPool works out when each node is out of the pool, from the first n
+// actions of the plan as scheduled so far. Nodes count as out until they're
+// back at full weight; those which haven't got there yet are expected to be
+// out for as long as outFor estimates, and nodes which are to be
+// decommissioned for good. Nodes in a cluster whose traffic has been failed
+// over are out until it's restored.
+func (c Calendar) outOfPool(startingState State, plan []MaintenanceAction, n int, schedule Schedule, outFor func(i int) time.Duration) map[string][2]time.Duration {
+	decommissioned := make(map[string]bool)
+	for _, action := range plan {
+		if _, ok := action.(*DecommissionNodeAction); ok {
+			decommissioned[action.NodeName()] = true
+		}
+	}
+	out := make(map[string][2]time.Duration)
+	for _, nodeState := range startingState.Nodes {
+		if nodeState.PoolWeight >= FullWeight {
+			continue
+		}
+		out[nodeState.Name] = [2]time.Duration{0, math.MaxInt64}
+		if decommissioned[nodeState.Name] {
+			continue
+		}
+		for i, action := range plan {
+			if action.NodeName() == nodeState.Name {
+				out[nodeState.Name] = [2]time.Duration{0, outFor(i)}
+				break
+			}
+		}
+	}
+	for i, action := range plan[:n] {
+		switch a := action.(type) {
+		case *DrainNodeFromPoolAction:
+			end := time.Duration(math.MaxInt64)
+			if !decommissioned[action.NodeName()] {
+				end = schedule[i].Start + outFor(i)
+			}
+			out[action.NodeName()] = [2]time.Duration{schedule[i].Start, end}
+		case *AddNodeToPoolAction, *RampWeightAction:
+			interval := out[action.NodeName()]
+			interval[1] = schedule[i].Start + outFor(i)
+			out[action.NodeName()] = interval
+		case *FailoverClusterTrafficAction:
+			for _, name := range a.drained {
+				out[name] = [2]time.Duration{schedule[i].Start, math.MaxInt64}
+			}
+		case *RestoreClusterTrafficAction:
+			for _, name := range a.restored {
+				interval := out[name]
+				interval[1] = schedule[i].End
+				out[name] = interval
+			}
+		}
+	}
+	return out
+}
+
+// failedOver works out when each cluster's traffic is failed over, from the
+// first n actions of the plan as scheduled so far.
+func (c Calendar) failedOver(plan []MaintenanceAction, n int, schedule Schedule) map[int][2]time.Duration {
+	out := make(map[int][2]time.Duration)
+	for i, action := range plan[:n] {
+		switch a := action.(type) {
+		case *FailoverClusterTrafficAction:
+			out[a.cluster] = [2]time.Duration{schedule[i].Start, math.MaxInt64}
+		case *RestoreClusterTrafficAction:
+			interval, found := out[a.cluster]
+			if !found {
+				// failed over before the plan started
+				interval[0] = math.MinInt64
+			}
+			interval[1] = schedule[i].End
+			out[a.cluster] = interval
+		}
+	}
+	return out
+}
+
+// shortOfCapacity reports whether, at some point between from and to, the
+// nodes left in the pool without the given ones couldn't serve the forecast
+// demand, given the clusters whose traffic is failed over; if so, it also
+// returns when that might next change.
+func (c Calendar) shortOfCapacity(startingState State, without []NodeState, outOfPool map[string][2]time.Duration, failedOver map[int][2]time.Duration, from, to time.Duration, safetyFactor float64) (bool, time.Duration) {
+	excluded := make(map[string]bool)
+	for _, nodeState := range without {
+		excluded[nodeState.Name] = true
+	}
+	checkpoints := []time.Duration{from}
+	for t := c.nextDemandChange(from); t < to; t = c.nextDemandChange(t) {
+		checkpoints = append(checkpoints, t)
+	}
+	for _, interval := range outOfPool {
+		if interval[0] > from && interval[0] < to {
+			checkpoints = append(checkpoints, interval[0])
+		}
+	}
+	for _, interval := range failedOver {
+		if interval[0] > from && interval[0] < to {
+			checkpoints = append(checkpoints, interval[0])
+		}
+	}
+
+	for _, t := range checkpoints {
+		var total float64
+		byCluster := make(map[int]float64)
+		for _, other := range startingState.Nodes {
+			if excluded[other.Name] || other.failed() {
+				continue
+			}
+			if interval, found := outOfPool[other.Name]; found && interval[0] <= t && t < interval[1] {
+				continue
+			}
+			total += other.Capacity
+			byCluster[other.Cluster] += other.Capacity
+		}
+		forecast := State{Demand: c.demandAt(t)}
+		for cluster, interval := range failedOver {
+			if interval[0] <= t && t < interval[1] {
+				forecast.Traffic = forecast.Traffic.withFailedOver(cluster, true)
+			}
+		}
+		demand := forecast.servedDemand()
+		short := total < demand.Global*safetyFactor
+		for _, nodeState := range without {
+			if byCluster[nodeState.Cluster] < demand.ByCluster[nodeState.Cluster]*safetyFactor {
+				short = true
+			}
+		}
+		if !short {
+			continue
+		}
+
+		// things may improve when demand next changes, or when another
+		// node returns to the pool or cluster is restored
+		retryAt := c.nextDemandChange(t)
+		for _, interval := range outOfPool {
+			if interval[1] > t && interval[1] < retryAt {
+				retryAt = interval[1]
+			}
+		}
+		for _, interval := range failedOver {
+			if interval[1] > t && interval[1] < retryAt {
+				retryAt = interval[1]
+			}
+		}
+		return true, retryAt
+	}
+	return false, 0
+}
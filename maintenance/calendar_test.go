@@ -0,0 +1,248 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCalendar_fitInWindow(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+	testCases := map[string]struct {
+		windows     []Window
+		offset      time.Duration
+		length      time.Duration
+		expected    time.Duration
+		expectNoFit bool
+	}{
+		"no windows": {
+			offset:   3 * time.Hour,
+			length:   time.Hour,
+			expected: 3 * time.Hour,
+		},
+		"inside window": {
+			windows:  []Window{{Start: 2 * time.Hour, End: 4 * time.Hour}},
+			offset:   3 * time.Hour,
+			length:   time.Hour,
+			expected: 3 * time.Hour,
+		},
+		"overruns window": {
+			windows:  []Window{{Start: 2 * time.Hour, End: 4 * time.Hour}},
+			offset:   3*time.Hour + time.Minute,
+			length:   time.Hour,
+			expected: day + 2*time.Hour,
+		},
+		"earliest of several windows": {
+			windows: []Window{
+				{Start: 20 * time.Hour, End: 21 * time.Hour},
+				{Start: 10 * time.Hour, End: 11 * time.Hour},
+			},
+			offset:   5 * time.Hour,
+			length:   time.Hour,
+			expected: 10 * time.Hour,
+		},
+		"window spanning midnight": {
+			windows:  []Window{{Start: 22 * time.Hour, End: 2 * time.Hour}},
+			offset:   day + time.Hour,
+			length:   time.Hour,
+			expected: day + time.Hour,
+		},
+		"window too short": {
+			windows:     []Window{{Start: 2 * time.Hour, End: 3 * time.Hour}},
+			offset:      5 * time.Hour,
+			length:      2 * time.Hour,
+			expected:    5 * time.Hour,
+			expectNoFit: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			c := Calendar{Start: start}
+			if tc.windows != nil {
+				c.Windows = map[int][]Window{1: tc.windows}
+			}
+			actual, ok := c.fitInWindow(1, tc.offset, tc.length)
+			if ok == tc.expectNoFit {
+				t.Errorf("expected fit %t, got %t", !tc.expectNoFit, ok)
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCalendar_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		calendar    Calendar
+		expectError bool
+	}{
+		"valid": {
+			calendar: Calendar{
+				DemandCurve: DemandCurve{
+					{At: 0, Demand: Demand{Global: 50}},
+					{At: 8 * time.Hour, Demand: Demand{Global: 150}},
+				},
+				Windows: map[int][]Window{1: {{Start: 22 * time.Hour, End: 2 * time.Hour}}},
+			},
+		},
+		"unsorted curve": {
+			calendar: Calendar{
+				DemandCurve: DemandCurve{
+					{At: 8 * time.Hour, Demand: Demand{Global: 150}},
+					{At: 0, Demand: Demand{Global: 50}},
+				},
+			},
+			expectError: true,
+		},
+		"curve beyond a day": {
+			calendar: Calendar{
+				DemandCurve: DemandCurve{{At: 25 * time.Hour}},
+			},
+			expectError: true,
+		},
+		"empty window": {
+			calendar: Calendar{
+				Windows: map[int][]Window{1: {{Start: time.Hour, End: time.Hour}}},
+			},
+			expectError: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			err := tc.calendar.Validate()
+			if tc.expectError && err == nil {
+				t.Error("expected an error, got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestPlanner_calendarUnschedulable(t *testing.T) {
+	node := func(name string, cluster int) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+			Capacity:         100,
+		}
+	}
+	startingState := State{Nodes: []NodeState{
+		node("app1-1", 1),
+		node("app2-1", 2),
+	}}
+	start := time.Date(2021, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		calendar *Calendar
+	}{
+		// each node is out of the pool for 7m, which won't fit in 5m
+		"window too short": {
+			calendar: &Calendar{
+				Start:   start,
+				Windows: map[int][]Window{1: {{Start: time.Hour, End: time.Hour + 5*time.Minute}}},
+			},
+		},
+		"demand never low enough": {
+			calendar: &Calendar{
+				Start: start,
+				DemandCurve: DemandCurve{
+					{At: 0, Demand: Demand{Global: 150}},
+					{At: 12 * time.Hour, Demand: Demand{Global: 180}},
+				},
+			},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			log.SetOutput(ioutil.Discard)
+			mp := &Planner{
+				Durations: Durations{Default: time.Minute},
+				Calendar:  tc.calendar,
+			}
+			schedule := mp.PlanScheduleForTargetRevision(startingState, 2)
+			log.SetOutput(os.Stdout)
+			if schedule != nil {
+				t.Errorf("expected no schedule, got:\n%v", schedule)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_calendar() {
+	log.SetFlags(0)
+	node := func(name string, cluster int) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+			Capacity:         100,
+		}
+	}
+	startingState := State{Nodes: []NodeState{
+		node("app1-1", 1),
+		node("app2-1", 2),
+	}}
+
+	// demand rises at 08:00, too high to drain either node until it falls
+	// again at 20:00; cluster 2 may only be touched after 22:00
+	calendar := &Calendar{
+		Start: time.Date(2021, time.March, 1, 7, 55, 0, 0, time.UTC),
+		DemandCurve: DemandCurve{
+			{At: 0, Demand: Demand{Global: 50}},
+			{At: 8 * time.Hour, Demand: Demand{Global: 150}},
+			{At: 20 * time.Hour, Demand: Demand{Global: 50}},
+		},
+		Windows: map[int][]Window{
+			2: {{Start: 22 * time.Hour, End: 2 * time.Hour}},
+		},
+	}
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{
+		Durations: Durations{Default: time.Minute},
+		Calendar:  calendar,
+	}
+	schedule := mp.PlanScheduleForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for _, sa := range schedule {
+		fmt.Printf("%s %s\n", calendar.Timestamp(sa.Start).Format("15:04"), sa.Action)
+	}
+	// Output:
+	// 20:00 Drain node from pool: app1-1
+	// 20:01 Stop app: app1-1
+	// 20:02 Update software: app1-1
+	// 20:03 Start app: app1-1
+	// 20:04 Health check: app1-1
+	// 20:05 Warm cache: app1-1
+	// 20:06 Add node to pool: app1-1
+	// 22:00 Drain node from pool: app2-1
+	// 22:01 Stop app: app2-1
+	// 22:02 Update software: app2-1
+	// 22:03 Start app: app2-1
+	// 22:04 Health check: app2-1
+	// 22:05 Warm cache: app2-1
+	// 22:06 Add node to pool: app2-1
+}
@@ -0,0 +1,214 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A CanaryPolicy picks nodes to upgrade and return to the pool ahead of the
+// rest of the fleet. Once they're back, a PauseForVerificationAction gives
+// operators the chance to check them before any other node is taken down.
+//
+// Canaries are the nodes listed in Nodes, plus any carrying all of Labels;
+// if neither is set, the first node by name is the canary.
+type CanaryPolicy struct {
+	Nodes  []string          `yaml:"nodes"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+// CanaryState records how far canary verification has got.
+type CanaryState struct {
+	// VerifiedFor is the latest revision whose canaries have been verified.
+	VerifiedFor int `yaml:"verifiedfor"`
+	// VerifiedPatchLevel is the latest OS patch level whose canaries have
+	// been verified.
+	VerifiedPatchLevel int `yaml:"verifiedpatchlevel"`
+	// VerifiedConfig is the latest configuration version whose canaries have
+	// been verified.
+	VerifiedConfig int `yaml:"verifiedconfig"`
+}
+
+// verified reports whether the canaries have been verified for the goal.
+func (cs CanaryState) verified(goal Goal) bool {
+	return cs.VerifiedFor >= goal.TargetRevision && cs.VerifiedPatchLevel >= goal.OSPatchLevel && cs.VerifiedConfig >= goal.Config.Version
+}
+
+// verify returns the state once the canaries have been verified for the
+// goal.
+func (cs CanaryState) verify(goal Goal) CanaryState {
+	if goal.TargetRevision > cs.VerifiedFor {
+		cs.VerifiedFor = goal.TargetRevision
+	}
+	if goal.OSPatchLevel > cs.VerifiedPatchLevel {
+		cs.VerifiedPatchLevel = goal.OSPatchLevel
+	}
+	if goal.Config.Version > cs.VerifiedConfig {
+		cs.VerifiedConfig = goal.Config.Version
+	}
+	return cs
+}
+
+// canaries returns the names of the nodes the policy picks, sorted.
+func (cp *CanaryPolicy) canaries(state State) []string {
+	var names []string
+	for _, nodeState := range state.Nodes {
+		if cp.isCanary(state, nodeState) {
+			names = append(names, nodeState.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (cp *CanaryPolicy) isCanary(state State, nodeState NodeState) bool {
+	if cp == nil {
+		return false
+	}
+	if len(cp.Nodes) == 0 && len(cp.Labels) == 0 {
+		for _, other := range state.Nodes {
+			if other.Name < nodeState.Name {
+				return false
+			}
+		}
+		return true
+	}
+	for _, name := range cp.Nodes {
+		if name == nodeState.Name {
+			return true
+		}
+	}
+	return len(cp.Labels) > 0 && nodeState.hasLabels(cp.Labels)
+}
+
+func (ns NodeState) hasLabels(labels map[string]string) bool {
+	for key, value := range labels {
+		if ns.Label(key) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// PauseForVerificationAction is a gate between the canaries and the rest of
+// the fleet: an executor should stop here until an operator has verified
+// the canaries running the target revision, OS patch level and
+// configuration.
+type PauseForVerificationAction struct {
+	Goal
+	Canary     *CanaryPolicy
+	canaries   []string
+	finalState State
+}
+
+func (pfva *PauseForVerificationAction) String() string {
+	return fmt.Sprintf("Pause for verification of canaries: %s", strings.Join(pfva.canaries, ", "))
+}
+
+func (pfva *PauseForVerificationAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	if startingState.Canary.verified(pfva.Goal) {
+		return nil
+	}
+	// only once every canary is serving the target revision
+	canaries := pfva.Canary.canaries(startingState)
+	if len(canaries) == 0 {
+		return nil
+	}
+	for _, name := range canaries {
+		i := startingState.indexOfNode(name)
+		if i < 0 {
+			return nil
+		}
+		nodeState := startingState.Nodes[i]
+		if !nodeState.upToDate(pfva.Goal) || !nodeState.configUpToDate(pfva.Config) || !nodeState.inPool() {
+			return nil
+		}
+	}
+	newState := startingState
+	newState.Canary = newState.Canary.verify(pfva.Goal)
+	return []MaintenanceAction{
+		&PauseForVerificationAction{
+			Goal:       pfva.Goal,
+			Canary:     pfva.Canary,
+			canaries:   canaries,
+			finalState: newState,
+		},
+	}
+}
+
+func (pfva *PauseForVerificationAction) FinalState() State {
+	return pfva.finalState
+}
+
+// NodeName is empty, since the action applies to the whole fleet.
+func (pfva *PauseForVerificationAction) NodeName() string {
+	return ""
+}
+
+func (pfva *PauseForVerificationAction) Kind() ActionKind {
+	return KindPauseForVerification
+}
+
+// CanaryConstraint keeps every node but the canaries in the load-balancer
+// pool, and on its old configuration, until the canaries have been verified.
+type CanaryConstraint struct {
+	Goal
+	Canary *CanaryPolicy
+}
+
+func (cc *CanaryConstraint) Name() string {
+	return "canaries-first"
+}
+
+func (cc *CanaryConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	i := state.indexOfNode(action.NodeName())
+	if i < 0 {
+		return nil
+	}
+	var holds bool
+	switch action.(type) {
+	case *DrainNodeFromPoolAction:
+		holds = (startGate{Goal: cc.Goal, Canary: cc.Canary}).holds(state, state.Nodes[i])
+	case *ReloadConfigAction:
+		// a hot reload doesn't take the node out of the pool
+		holds = !state.Canary.verified(cc.Goal) && !cc.Canary.isCanary(state, state.Nodes[i])
+	}
+	if holds {
+		return fmt.Errorf("node %s must wait until canaries %s have been verified", action.NodeName(), strings.Join(cc.Canary.canaries(state), ", "))
+	}
+	return nil
+}
+
+// A startGate holds nodes back from starting maintenance while others go
+// ahead of them: until their Dependencies are met, until the canaries
+// picked by Canary have been verified, or until the pool has the capacity,
+// given SafetyFactor, to do without them. Nodes the Filter doesn't allow are
+// held back for good.
+type startGate struct {
+	Goal
+	Dependencies Dependencies
+	Canary       *CanaryPolicy
+	SafetyFactor float64
+	Filter       NodeFilter
+}
+
+// holds reports whether a node which hasn't yet started maintenance must
+// wait before it does.
+func (sg startGate) holds(state State, nodeState NodeState) bool {
+	if nodeState.upToDate(sg.Goal) || !nodeState.inPool() {
+		return false
+	}
+	if !sg.Filter.Allows(nodeState) {
+		return true
+	}
+	if sg.Dependencies.unmetFor(state, nodeState, sg.TargetRevision) != nil {
+		return true
+	}
+	if waitsForCapacity(state, nodeState, sg.Goal, sg.SafetyFactor, sg.Filter) {
+		return true
+	}
+	if sg.Canary == nil || state.Canary.verified(sg.Goal) {
+		return false
+	}
+	return !sg.Canary.isCanary(state, nodeState)
+}
@@ -0,0 +1,225 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCanaryPolicy_canaries(t *testing.T) {
+	t.Parallel()
+
+	state := State{Nodes: []NodeState{
+		{Name: "app2-1", Cluster: 2},
+		{Name: "app1-2", Cluster: 1, Labels: map[string]string{"canary": "true"}},
+		{Name: "app1-1", Cluster: 1},
+		{Name: "app2-2", Cluster: 2, Labels: map[string]string{"canary": "true"}},
+	}}
+
+	testCases := map[string]struct {
+		policy   CanaryPolicy
+		expected []string
+	}{
+		"default to first node by name": {
+			expected: []string{"app1-1"},
+		},
+		"by name": {
+			policy:   CanaryPolicy{Nodes: []string{"app2-1"}},
+			expected: []string{"app2-1"},
+		},
+		"by label": {
+			policy:   CanaryPolicy{Labels: map[string]string{"canary": "true"}},
+			expected: []string{"app1-2", "app2-2"},
+		},
+		"by name and label": {
+			policy: CanaryPolicy{
+				Nodes:  []string{"app2-1"},
+				Labels: map[string]string{"canary": "true", ClusterLabel: "2"},
+			},
+			expected: []string{"app2-1", "app2-2"},
+		},
+		"no match": {
+			policy: CanaryPolicy{Nodes: []string{"app3-1"}},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			actual := tc.policy.canaries(state)
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_canary() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+			Labels:           map[string]string{"canary": "true"},
+		},
+		NodeState{
+			Name:             "app2-2",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{
+		Canary: &CanaryPolicy{Labels: map[string]string{"canary": "true"}},
+	}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Drain node from pool: app2-1
+	// Stage 2:
+	//     Stop app: app2-1
+	// Stage 3:
+	//     Update software: app2-1
+	// Stage 4:
+	//     Start app: app2-1
+	// Stage 5:
+	//     Health check: app2-1
+	// Stage 6:
+	//     Warm cache: app2-1
+	// Stage 7:
+	//     Add node to pool: app2-1
+	// Stage 8:
+	//     Pause for verification of canaries: app2-1
+	// Stage 9:
+	//     Drain node from pool: app1-1
+	//     Drain node from pool: app1-2
+	// Stage 10:
+	//     Stop app: app1-1
+	//     Stop app: app1-2
+	// Stage 11:
+	//     Update software: app1-1
+	//     Update software: app1-2
+	// Stage 12:
+	//     Start app: app1-1
+	//     Start app: app1-2
+	// Stage 13:
+	//     Health check: app1-1
+	//     Health check: app1-2
+	// Stage 14:
+	//     Warm cache: app1-1
+	//     Warm cache: app1-2
+	// Stage 15:
+	//     Add node to pool: app1-1
+	//     Add node to pool: app1-2
+	// Stage 16:
+	//     Drain node from pool: app2-2
+	// Stage 17:
+	//     Stop app: app2-2
+	// Stage 18:
+	//     Update software: app2-2
+	// Stage 19:
+	//     Start app: app2-2
+	// Stage 20:
+	//     Health check: app2-2
+	// Stage 21:
+	//     Warm cache: app2-2
+	// Stage 22:
+	//     Add node to pool: app2-2
+}
+
+func TestPlanner_canaryGoal(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string) NodeState {
+		return NodeState{Name: name, Cluster: 1, SoftwareRevision: 2, OSPatchLevel: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true}
+	}
+
+	testCases := map[string]struct {
+		planner  Planner
+		canary   CanaryState
+		expected []string
+	}{
+		"OS patch": {
+			planner:  Planner{OSPatchLevel: 2},
+			canary:   CanaryState{VerifiedFor: 2, VerifiedPatchLevel: 1},
+			expected: []string{"app1-1", "pause", "app1-2"},
+		},
+		"OS patch already verified": {
+			planner:  Planner{OSPatchLevel: 2},
+			canary:   CanaryState{VerifiedFor: 2, VerifiedPatchLevel: 2},
+			expected: []string{"app1-1", "app1-2"},
+		},
+		"config": {
+			planner:  Planner{Config: ConfigChange{Version: 3}},
+			canary:   CanaryState{VerifiedFor: 2, VerifiedPatchLevel: 1},
+			expected: []string{"app1-1", "pause", "app1-2"},
+		},
+		"hot-reloaded config": {
+			planner:  Planner{Config: ConfigChange{Version: 3, HotReload: true}},
+			canary:   CanaryState{VerifiedFor: 2, VerifiedPatchLevel: 1},
+			expected: []string{"reload app1-1", "pause", "reload app1-2"},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			startingState := State{Nodes: []NodeState{node("app1-1"), node("app1-2")}, Canary: tc.canary}
+			mp := tc.planner
+			mp.Canary = &CanaryPolicy{}
+			var actual []string
+			for _, action := range mp.PlanActionsForTargetRevision(startingState, 2) {
+				switch action.Kind() {
+				case KindDrainNodeFromPool:
+					actual = append(actual, action.NodeName())
+				case KindReloadConfig:
+					actual = append(actual, "reload "+action.NodeName())
+				case KindPauseForVerification:
+					actual = append(actual, "pause")
+				}
+			}
+			if !reflect.DeepEqual(tc.expected, actual) {
+				t.Errorf("expected drains and pauses %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Demand is the traffic, in requests per second, which nodes in the
+// load-balancer pool must be able to serve.
+type Demand struct {
+	// Global is served by the pool as a whole, across all clusters.
+	Global float64 `yaml:"global,omitempty"`
+	// ByCluster is served by each cluster's own nodes, keyed by cluster.
+	ByCluster map[int]float64 `yaml:"bycluster,omitempty"`
+}
+
+func (d Demand) isZero() bool {
+	return d.Global == 0 && len(d.ByCluster) == 0
+}
+
+// inPoolCapacity sums the Capacity of the nodes in the load-balancer pool,
+// other than failed ones and those in clusters whose traffic has been failed
+// over, in proportion to their weight, both overall and by cluster.
+func inPoolCapacity(state State) (float64, map[int]float64) {
+	var total float64
+	byCluster := make(map[int]float64)
+	for _, nodeState := range state.Nodes {
+		if nodeState.serving() && !state.Traffic.failedOver(nodeState.Cluster) {
+			total += nodeState.weightedCapacity()
+			byCluster[nodeState.Cluster] += nodeState.weightedCapacity()
+		}
+	}
+	return total, byCluster
+}
+
+func safetyFactorOrDefault(safetyFactor float64) float64 {
+	if safetyFactor == 0 {
+		return 1
+	}
+	return safetyFactor
+}
+
+// CapacityConstraint requires the nodes in the load-balancer pool to have
+// the capacity to serve the State's Demand multiplied by SafetyFactor, both
+// globally and in each cluster with a demand of its own. SafetyFactor
+// defaults to 1. While a cluster's traffic is failed over, the rest of the
+// pool must serve every cluster's demand, as well as the global demand.
+// Nodes are drained in batches: none may be while others in its cluster are
+// part-way through maintenance, not counting any the Filter doesn't allow to
+// progress.
+type CapacityConstraint struct {
+	Goal
+	SafetyFactor float64
+	Filter       NodeFilter
+}
+
+func (cc *CapacityConstraint) Name() string {
+	return "capacity"
+}
+
+func (cc *CapacityConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	demand := nextState.servedDemand()
+	if demand.isZero() {
+		return nil
+	}
+	if _, ok := action.(*DrainNodeFromPoolAction); ok {
+		i := state.indexOfNode(action.NodeName())
+		if i >= 0 && waitsForCapacity(state, state.Nodes[i], cc.Goal, cc.SafetyFactor, cc.Filter) {
+			return fmt.Errorf("node %s must wait for capacity in cluster %d before it's drained", action.NodeName(), state.Nodes[i].Cluster)
+		}
+	}
+
+	safetyFactor := safetyFactorOrDefault(cc.SafetyFactor)
+	beforeTotal, beforeByCluster := inPoolCapacity(state)
+	afterTotal, afterByCluster := inPoolCapacity(nextState)
+
+	// don't object to pools which were already short of capacity, so long as
+	// they aren't getting any shorter
+	if required := demand.Global * safetyFactor; afterTotal < required && afterTotal < beforeTotal {
+		return fmt.Errorf("the pool would have capacity %g, but needs %g", afterTotal, required)
+	}
+	clusters := make([]int, 0, len(demand.ByCluster))
+	for cluster := range demand.ByCluster {
+		clusters = append(clusters, cluster)
+	}
+	sort.Ints(clusters)
+	for _, cluster := range clusters {
+		required := demand.ByCluster[cluster] * safetyFactor
+		if afterByCluster[cluster] < required && afterByCluster[cluster] < beforeByCluster[cluster] {
+			return fmt.Errorf("cluster %d would have capacity %g in the pool, but needs %g", cluster, afterByCluster[cluster], required)
+		}
+	}
+	return nil
+}
+
+// waitsForCapacity reports whether a node which hasn't yet started
+// maintenance must wait for capacity before it's drained: because taking it
+// out of the pool would leave the pool short of capacity to serve the
+// State's Demand, or because other nodes in its cluster which the filter
+// allows to progress are already past being drained, or are new nodes yet to
+// join the pool. In the latter case, capacity they free up as they return is
+// left for them to finish, so nodes are drained in batches rather than
+// trickling in and stalling the batch ahead of them.
+func waitsForCapacity(state State, nodeState NodeState, goal Goal, safetyFactor float64, filter NodeFilter) bool {
+	if state.Demand.isZero() || nodeState.Capacity == 0 || !nodeState.inPool() {
+		return false
+	}
+	for _, other := range state.Nodes {
+		if other.Cluster != nodeState.Cluster || other.inPool() || other.failed() || !filter.Allows(other) {
+			continue
+		}
+		if !other.AppRunning || other.upToDate(goal) {
+			return true
+		}
+	}
+
+	safetyFactor = safetyFactorOrDefault(safetyFactor)
+	total, byCluster := inPoolCapacity(state)
+	served := state.servedDemand()
+	if total-nodeState.weightedCapacity() < served.Global*safetyFactor {
+		return true
+	}
+	if demand, found := served.ByCluster[nodeState.Cluster]; found {
+		return byCluster[nodeState.Cluster]-nodeState.weightedCapacity() < demand*safetyFactor
+	}
+	return false
+}
+
+// undrainableNodes returns the names of nodes which need maintenance, and
+// which the filter allows, but couldn't be drained without leaving the pool
+// short of capacity, even with every other node in it.
+func undrainableNodes(state State, goal Goal, safetyFactor float64, filter NodeFilter) []string {
+	if state.Demand.isZero() {
+		return nil
+	}
+	safetyFactor = safetyFactorOrDefault(safetyFactor)
+	served := state.servedDemand()
+	var total float64
+	byCluster := make(map[int]float64)
+	for _, nodeState := range state.Nodes {
+		if nodeState.failed() {
+			continue
+		}
+		total += nodeState.Capacity
+		byCluster[nodeState.Cluster] += nodeState.Capacity
+	}
+
+	var undrainable []string
+	for _, nodeState := range state.Nodes {
+		if nodeState.upToDate(goal) || !nodeState.inPool() || nodeState.Capacity == 0 || !filter.Allows(nodeState) {
+			continue
+		}
+		short := total-nodeState.Capacity < served.Global*safetyFactor
+		if demand, found := served.ByCluster[nodeState.Cluster]; found {
+			short = short || byCluster[nodeState.Cluster]-nodeState.Capacity < demand*safetyFactor
+		}
+		if short {
+			undrainable = append(undrainable, nodeState.Name)
+		}
+	}
+	return undrainable
+}
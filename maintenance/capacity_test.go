@@ -0,0 +1,208 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestCapacityConstraint_Check(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, cluster int, capacity float64, inPool bool) NodeState {
+		nodeState := NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			CacheWarmed:      true,
+			Capacity:         capacity,
+		}
+		if inPool {
+			nodeState.PoolWeight = FullWeight
+		}
+		return nodeState
+	}
+
+	testCases := map[string]struct {
+		state        State
+		safetyFactor float64
+		expectError  bool
+	}{
+		"no demand": {
+			state: State{Nodes: []NodeState{
+				node("app1-1", 1, 100, true),
+			}},
+		},
+		"enough global capacity": {
+			state: State{
+				Nodes: []NodeState{
+					node("app1-1", 1, 100, true),
+					node("app2-1", 2, 100, true),
+				},
+				Demand: Demand{Global: 100},
+			},
+		},
+		"short of global capacity": {
+			state: State{
+				Nodes: []NodeState{
+					node("app1-1", 1, 100, true),
+					node("app2-1", 2, 100, true),
+				},
+				Demand: Demand{Global: 150},
+			},
+			expectError: true,
+		},
+		"short once safety factor applied": {
+			state: State{
+				Nodes: []NodeState{
+					node("app1-1", 1, 100, true),
+					node("app2-1", 2, 100, true),
+				},
+				Demand: Demand{Global: 100},
+			},
+			safetyFactor: 1.5,
+			expectError:  true,
+		},
+		"short of cluster capacity": {
+			state: State{
+				Nodes: []NodeState{
+					node("app1-1", 1, 100, true),
+					node("app1-2", 1, 100, true),
+					node("app2-1", 2, 100, true),
+				},
+				Demand: Demand{ByCluster: map[int]float64{1: 150}},
+			},
+			expectError: true,
+		},
+		"other cluster's demand ignored": {
+			state: State{
+				Nodes: []NodeState{
+					node("app1-1", 1, 100, true),
+					node("app2-1", 2, 100, true),
+				},
+				Demand: Demand{ByCluster: map[int]float64{2: 100}},
+			},
+		},
+		"short while another node ramps up": {
+			state: State{
+				Nodes: []NodeState{
+					node("app1-1", 1, 100, true),
+					NodeState{Name: "app2-1", Cluster: 2, SoftwareRevision: 2, AppRunning: true, PoolWeight: 50, CacheWarmed: true, Capacity: 100},
+				},
+				Demand: Demand{Global: 100},
+			},
+			expectError: true,
+		},
+		"nodes without capacity": {
+			state: State{
+				Nodes: []NodeState{
+					node("app1-1", 1, 0, true),
+					node("app2-1", 2, 0, true),
+				},
+				Demand: Demand{Global: 100},
+			},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			prototype := &DrainNodeFromPoolAction{Goal: Goal{TargetRevision: 2}}
+			actions := prototype.CloneForValidTargets(tc.state)
+			if len(actions) == 0 {
+				t.Fatal("expected an action to check, got none")
+			}
+			cc := &CapacityConstraint{Goal: Goal{TargetRevision: 2}, SafetyFactor: tc.safetyFactor}
+			err := cc.Check(tc.state, actions[0], actions[0].FinalState())
+			if tc.expectError && err == nil {
+				t.Error("expected an error, got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_capacity() {
+	log.SetFlags(0)
+	node := func(name string) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+			Capacity:         100,
+		}
+	}
+	startingState := State{
+		Nodes: []NodeState{
+			node("app1-1"),
+			node("app1-2"),
+			node("app1-3"),
+			node("app1-4"),
+		},
+		Demand: Demand{ByCluster: map[int]float64{1: 150}},
+	}
+
+	// with 20% headroom, at most two of the four nodes may be drained at once
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{SafetyFactor: 1.2}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Drain node from pool: app1-1
+	//     Drain node from pool: app1-2
+	// Stage 2:
+	//     Stop app: app1-1
+	//     Stop app: app1-2
+	// Stage 3:
+	//     Update software: app1-1
+	//     Update software: app1-2
+	// Stage 4:
+	//     Start app: app1-1
+	//     Start app: app1-2
+	// Stage 5:
+	//     Health check: app1-1
+	//     Health check: app1-2
+	// Stage 6:
+	//     Warm cache: app1-1
+	//     Warm cache: app1-2
+	// Stage 7:
+	//     Add node to pool: app1-1
+	//     Add node to pool: app1-2
+	// Stage 8:
+	//     Drain node from pool: app1-3
+	//     Drain node from pool: app1-4
+	// Stage 9:
+	//     Stop app: app1-3
+	//     Stop app: app1-4
+	// Stage 10:
+	//     Update software: app1-3
+	//     Update software: app1-4
+	// Stage 11:
+	//     Start app: app1-3
+	//     Start app: app1-4
+	// Stage 12:
+	//     Health check: app1-3
+	//     Health check: app1-4
+	// Stage 13:
+	//     Warm cache: app1-3
+	//     Warm cache: app1-4
+	// Stage 14:
+	//     Add node to pool: app1-3
+	//     Add node to pool: app1-4
+}
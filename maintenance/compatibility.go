@@ -0,0 +1,109 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+// An Incompatibility declares that two revisions can't serve traffic at the
+// same time. If Within is set they only clash among nodes sharing a value for
+// that label, e.g. "cluster"; otherwise they clash anywhere in the fleet.
+type Incompatibility struct {
+	Revisions [2]int
+	Within    string
+}
+
+func (i Incompatibility) String() string {
+	if i.Within != "" {
+		return fmt.Sprintf("revisions %d and %d within a %s", i.Revisions[0], i.Revisions[1], i.Within)
+	}
+	return fmt.Sprintf("revisions %d and %d", i.Revisions[0], i.Revisions[1])
+}
+
+// A CompatibilityMatrix lists the pairs of revisions which can't serve
+// traffic side by side; any pair it doesn't list is compatible.
+type CompatibilityMatrix []Incompatibility
+
+// Validate rejects entries which don't name two different revisions.
+func (cm CompatibilityMatrix) Validate() error {
+	for _, incompatibility := range cm {
+		if incompatibility.Revisions[0] == incompatibility.Revisions[1] {
+			return fmt.Errorf("%s: a revision is always compatible with itself", incompatibility)
+		}
+	}
+	return nil
+}
+
+// CompatibilityConstraint refuses actions which would leave incompatible
+// revisions serving traffic together, i.e. both in the load-balancer pool.
+// Usually that means the planner drains every node of the old revision in a
+// group before adding any node of the new revision back to it.
+type CompatibilityConstraint struct {
+	Matrix CompatibilityMatrix
+}
+
+func (cc *CompatibilityConstraint) Name() string {
+	return "revision-compatibility"
+}
+
+func (cc *CompatibilityConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	for _, incompatibility := range cc.Matrix {
+		before := clashingGroups(state, incompatibility)
+		for withinValue := range clashingGroups(nextState, incompatibility) {
+			// don't object to clashes we started with, so long as no new
+			// ones appear
+			if before[withinValue] {
+				continue
+			}
+			if incompatibility.Within != "" {
+				return fmt.Errorf("%s would serve together in %s %q", incompatibility, incompatibility.Within, withinValue)
+			}
+			return fmt.Errorf("%s would serve together", incompatibility)
+		}
+	}
+	return nil
+}
+
+// clashingGroups returns the values of the incompatibility's Within label
+// for which both of its revisions are serving.
+func clashingGroups(state State, incompatibility Incompatibility) map[string]bool {
+	serving := make(map[string][2]bool)
+	for _, nodeState := range state.Nodes {
+		if !nodeState.serving() {
+			continue
+		}
+		withinValue := nodeState.Label(incompatibility.Within)
+		revs := serving[withinValue]
+		for i, rev := range incompatibility.Revisions {
+			if nodeState.SoftwareRevision == rev {
+				revs[i] = true
+			}
+		}
+		serving[withinValue] = revs
+	}
+	clashing := make(map[string]bool)
+	for withinValue, revs := range serving {
+		if revs[0] && revs[1] {
+			clashing[withinValue] = true
+		}
+	}
+	return clashing
+}
+
+// mixedRevisions reports whether nodes in the load-balancer pool are running
+// more than one revision.
+func mixedRevisions(state State) bool {
+	first := -1
+	for _, nodeState := range state.Nodes {
+		if !nodeState.serving() {
+			continue
+		}
+		if first < 0 {
+			first = nodeState.SoftwareRevision
+			continue
+		}
+		if nodeState.SoftwareRevision != first {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestCompatibilityConstraint_Check(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, cluster, rev int, inPool bool) NodeState {
+		nodeState := NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: rev,
+			AppRunning:       true,
+			CacheWarmed:      true,
+			Healthy:          true,
+		}
+		if inPool {
+			nodeState.PoolWeight = FullWeight
+		}
+		return nodeState
+	}
+
+	testCases := map[string]struct {
+		matrix      CompatibilityMatrix
+		state       State
+		expectError bool
+	}{
+		"compatible revisions": {
+			matrix: CompatibilityMatrix{
+				{Revisions: [2]int{1, 3}},
+			},
+			state: State{Nodes: []NodeState{
+				node("app1-1", 1, 2, false),
+				node("app1-2", 1, 1, true),
+			}},
+		},
+		"clash in cluster": {
+			matrix: CompatibilityMatrix{
+				{Revisions: [2]int{1, 2}, Within: ClusterLabel},
+			},
+			state: State{Nodes: []NodeState{
+				node("app1-1", 1, 2, false),
+				node("app1-2", 1, 1, true),
+			}},
+			expectError: true,
+		},
+		"no clash across clusters": {
+			matrix: CompatibilityMatrix{
+				{Revisions: [2]int{1, 2}, Within: ClusterLabel},
+			},
+			state: State{Nodes: []NodeState{
+				node("app1-1", 1, 2, false),
+				node("app2-1", 2, 1, true),
+			}},
+		},
+		"clash across fleet": {
+			matrix: CompatibilityMatrix{
+				{Revisions: [2]int{2, 1}},
+			},
+			state: State{Nodes: []NodeState{
+				node("app1-1", 1, 2, false),
+				node("app2-1", 2, 1, true),
+			}},
+			expectError: true,
+		},
+		"existing clash tolerated": {
+			matrix: CompatibilityMatrix{
+				{Revisions: [2]int{1, 2}},
+			},
+			state: State{Nodes: []NodeState{
+				node("app1-1", 1, 2, false),
+				node("app1-2", 1, 2, true),
+				node("app2-1", 2, 1, true),
+			}},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			prototype := &AddNodeToPoolAction{Goal: Goal{TargetRevision: 2}}
+			actions := prototype.CloneForValidTargets(tc.state)
+			if len(actions) != 1 {
+				t.Fatalf("expected 1 action to check, got %d", len(actions))
+			}
+			cc := &CompatibilityConstraint{Matrix: tc.matrix}
+			err := cc.Check(tc.state, actions[0], actions[0].FinalState())
+			if tc.expectError && err == nil {
+				t.Error("expected an error, got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestCostModel_mixedRevisionPenalty(t *testing.T) {
+	t.Parallel()
+
+	costs := CostModel{MixedRevisionStepPenalty: 5}
+	state := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 2,
+			AppRunning:       true,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+	prototype := &AddNodeToPoolAction{Goal: Goal{TargetRevision: 2}}
+	actions := prototype.CloneForValidTargets(state)
+	if len(actions) != 1 {
+		t.Fatalf("expected 1 action, got %d", len(actions))
+	}
+	if actual := costs.Cost(actions[0]); actual != 6 {
+		t.Errorf("expected cost 6, got %f", actual)
+	}
+}
+
+func ExamplePlanner_compatibility() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+
+	// nodes could otherwise go through maintenance one at a time
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{
+		LooseStepSync: true,
+		Compatibility: CompatibilityMatrix{
+			{Revisions: [2]int{1, 2}, Within: ClusterLabel},
+		},
+	}
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for _, action := range plan {
+		fmt.Println(action)
+	}
+	// Output:
+	// Drain node from pool: app1-1
+	// Drain node from pool: app1-2
+	// Stop app: app1-1
+	// Stop app: app1-2
+	// Update software: app1-1
+	// Update software: app1-2
+	// Start app: app1-1
+	// Start app: app1-2
+	// Health check: app1-1
+	// Health check: app1-2
+	// Warm cache: app1-1
+	// Warm cache: app1-2
+	// Add node to pool: app1-1
+	// Add node to pool: app1-2
+}
@@ -0,0 +1,147 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+const (
+	KindUpdateConfig ActionKind = "updateconfig"
+	KindReloadConfig ActionKind = "reloadconfig"
+)
+
+// A ConfigChange rolls a new version of the app's configuration out to every
+// node. The zero value changes nothing.
+type ConfigChange struct {
+	// Version is the configuration version every node must reach.
+	Version int `yaml:"version"`
+	// HotReload is set if running apps can load the new configuration in
+	// place; otherwise they must be restarted to pick it up.
+	HotReload bool `yaml:"hotreload"`
+}
+
+// configUpToDate reports whether a node's app has loaded the given
+// configuration, or a later one.
+func (ns NodeState) configUpToDate(config ConfigChange) bool {
+	return ns.ConfigVersion >= config.Version
+}
+
+// newConfigVersion returns the configuration version of a node provisioned
+// in place of, or as a copy of, the given one.
+func newConfigVersion(nodeState NodeState, config ConfigChange) int {
+	if nodeState.ConfigVersion > config.Version {
+		return nodeState.ConfigVersion
+	}
+	return config.Version
+}
+
+// configKindForNode returns the kind of action which takes a node through the
+// config step of its pipeline: a running node can only have got there if the
+// change is hot-reloadable, so it's reloaded in place.
+func configKindForNode(nodeState NodeState, config ConfigChange) ActionKind {
+	if config.HotReload && nodeState.AppRunning {
+		return KindReloadConfig
+	}
+	return KindUpdateConfig
+}
+
+// UpdateConfigAction writes the new configuration to a stopped node, for its
+// app to load when it's started.
+type UpdateConfigAction struct {
+	Goal
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (uca *UpdateConfigAction) String() string {
+	return fmt.Sprintf("Update config: %s", uca.nodeName)
+}
+
+func (uca *UpdateConfigAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !uca.Filter.Allows(nodeState) {
+			continue
+		}
+		if nextKindForNode(nodeState, uca.Goal) != KindUpdateConfig {
+			continue
+		}
+		if configKindForNode(nodeState, uca.Config) != KindUpdateConfig {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.ConfigVersion = uca.Config.Version
+
+		out = append(out, &UpdateConfigAction{
+			Goal:       uca.Goal,
+			Filter:     uca.Filter,
+			nodeName:   nodeState.Name,
+			finalState: startingState.withNode(i, newNodeState),
+		})
+	}
+	return out
+}
+
+func (uca *UpdateConfigAction) FinalState() State {
+	return uca.finalState
+}
+
+func (uca *UpdateConfigAction) NodeName() string {
+	return uca.nodeName
+}
+
+func (uca *UpdateConfigAction) Kind() ActionKind {
+	return KindUpdateConfig
+}
+
+// ReloadConfigAction has a running node's app load a hot-reloadable
+// configuration change in place. Nodes which need no other maintenance stay
+// in the pool throughout, and needn't keep in step with the rest of their
+// group.
+type ReloadConfigAction struct {
+	Goal
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (rca *ReloadConfigAction) String() string {
+	return fmt.Sprintf("Reload config: %s", rca.nodeName)
+}
+
+func (rca *ReloadConfigAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !rca.Filter.Allows(nodeState) {
+			continue
+		}
+		if nextKindForNode(nodeState, rca.Goal) != KindUpdateConfig {
+			continue
+		}
+		if configKindForNode(nodeState, rca.Config) != KindReloadConfig {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.ConfigVersion = rca.Config.Version
+
+		out = append(out, &ReloadConfigAction{
+			Goal:       rca.Goal,
+			Filter:     rca.Filter,
+			nodeName:   nodeState.Name,
+			finalState: startingState.withNode(i, newNodeState),
+		})
+	}
+	return out
+}
+
+func (rca *ReloadConfigAction) FinalState() State {
+	return rca.finalState
+}
+
+func (rca *ReloadConfigAction) NodeName() string {
+	return rca.nodeName
+}
+
+func (rca *ReloadConfigAction) Kind() ActionKind {
+	return KindReloadConfig
+}
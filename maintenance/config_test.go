@@ -0,0 +1,135 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func Test_configActions_CloneForValidTargets(t *testing.T) {
+	t.Parallel()
+
+	inPool := NodeState{Name: "app1-1", SoftwareRevision: 2, ConfigVersion: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true}
+	stopped := NodeState{Name: "app1-2", SoftwareRevision: 2, ConfigVersion: 1}
+	oldRevision := NodeState{Name: "app1-3", SoftwareRevision: 1, ConfigVersion: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true}
+	loaded := NodeState{Name: "app1-4", SoftwareRevision: 2, ConfigVersion: 2, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true}
+	startingState := State{Nodes: []NodeState{inPool, stopped, oldRevision, loaded}}
+
+	testCases := map[string]struct {
+		config   ConfigChange
+		expected []string
+	}{
+		"hot reload": {
+			config: ConfigChange{Version: 2, HotReload: true},
+			expected: []string{
+				"Reload config: app1-1",
+				"Update config: app1-2",
+			},
+		},
+		"restart required": {
+			config: ConfigChange{Version: 2},
+			expected: []string{
+				"Update config: app1-2",
+			},
+		},
+		"no change": {
+			config: ConfigChange{Version: 1},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			prototypes := []MaintenanceAction{
+				&ReloadConfigAction{Goal: Goal{TargetRevision: 2, Config: tc.config}},
+				&UpdateConfigAction{Goal: Goal{TargetRevision: 2, Config: tc.config}},
+			}
+			var actual []string
+			for _, prototype := range prototypes {
+				for _, action := range prototype.CloneForValidTargets(startingState) {
+					actual = append(actual, action.String())
+					i := action.FinalState().indexOfNode(action.NodeName())
+					if action.FinalState().Nodes[i].ConfigVersion != tc.config.Version {
+						t.Errorf("%s: expected config version %d, got %d", action, tc.config.Version, action.FinalState().Nodes[i].ConfigVersion)
+					}
+				}
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_hotReload() {
+	log.SetFlags(0)
+	// app1-1 needs a new revision as well as the new config, so it loads the
+	// config on restart; the others reload it in place
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			ConfigVersion:    1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 2,
+			ConfigVersion:    1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 2,
+			ConfigVersion:    1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{Config: ConfigChange{Version: 2, HotReload: true}}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Drain node from pool: app1-1
+	// Stage 2:
+	//     Stop app: app1-1
+	// Stage 3:
+	//     Update software: app1-1
+	// Stage 4:
+	//     Update config: app1-1
+	// Stage 5:
+	//     Reload config: app1-2
+	//     Reload config: app2-1
+	// Stage 6:
+	//     Start app: app1-1
+	// Stage 7:
+	//     Health check: app1-1
+	// Stage 8:
+	//     Warm cache: app1-1
+	// Stage 9:
+	//     Add node to pool: app1-1
+}
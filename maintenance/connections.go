@@ -0,0 +1,110 @@
+package maintenance
+
+import (
+	"fmt"
+	"time"
+)
+
+const KindWaitForDrain ActionKind = "waitfordrain"
+
+// A DrainPolicy decides what happens to connections still open to a node's
+// app once it's been drained from the pool.
+type DrainPolicy struct {
+	// Timeout is how long to wait for connections to close before giving
+	// up. If it's set, every node drained waits before its app is stopped;
+	// zero leaves the wait, and its length, to the executor, and only nodes
+	// with ActiveConnections wait.
+	Timeout time.Duration `yaml:"timeout"`
+	// ForceStop lets an app be stopped with connections still open once
+	// the wait for them has timed out. Otherwise the rollout halts until
+	// someone intervenes.
+	ForceStop bool `yaml:"forcestop"`
+}
+
+// Validate rejects a negative timeout.
+func (dp DrainPolicy) Validate() error {
+	if dp.Timeout < 0 {
+		return fmt.Errorf("negative Timeout %s", dp.Timeout)
+	}
+	return nil
+}
+
+// mayStop reports whether a node's app may be stopped given the connections
+// still open to it: only once any wait for them has been planned, and then
+// only if there are none, or if the wait timed out and forceStop is set.
+func (ns NodeState) mayStop(forceStop bool) bool {
+	if ns.AwaitingConnections {
+		return false
+	}
+	return ns.ActiveConnections == 0 || (ns.DrainTimedOut && forceStop)
+}
+
+// awaitsConnections reports whether a node must wait for its connections to
+// close before its app is stopped.
+func (ns NodeState) awaitsConnections() bool {
+	return ns.AwaitingConnections || (ns.ActiveConnections > 0 && !ns.DrainTimedOut)
+}
+
+// TimedOutDrains returns the names of nodes which can't progress towards the
+// target revision without intervention, because connections to them didn't
+// close before the wait for them timed out, and forceStop isn't set.
+func TimedOutDrains(state State, forceStop bool) []string {
+	var timedOut []string
+	for _, nodeState := range state.Nodes {
+		if nodeState.AppRunning && nodeState.DrainTimedOut && !nodeState.mayStop(forceStop) {
+			timedOut = append(timedOut, nodeState.Name)
+		}
+	}
+	return timedOut
+}
+
+// WaitForDrainAction waits, for up to Timeout, for connections to a drained
+// node's app to close before it's stopped. An executor should set the node's
+// DrainTimedOut if any are still open when it gives up.
+type WaitForDrainAction struct {
+	Goal
+	Timeout    time.Duration
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (wfda *WaitForDrainAction) String() string {
+	return fmt.Sprintf("Wait for connections to drain: %s", wfda.nodeName)
+}
+
+func (wfda *WaitForDrainAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !wfda.Filter.Allows(nodeState) || !nodeState.awaitsConnections() {
+			continue
+		}
+		if nextKindForNode(nodeState, wfda.Goal) != KindStopApp {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.ActiveConnections = 0
+		newNodeState.AwaitingConnections = false
+
+		out = append(out, &WaitForDrainAction{
+			Goal:       wfda.Goal,
+			Timeout:    wfda.Timeout,
+			Filter:     wfda.Filter,
+			nodeName:   nodeState.Name,
+			finalState: startingState.withNode(i, newNodeState),
+		})
+	}
+	return out
+}
+
+func (wfda *WaitForDrainAction) FinalState() State {
+	return wfda.finalState
+}
+
+func (wfda *WaitForDrainAction) NodeName() string {
+	return wfda.nodeName
+}
+
+func (wfda *WaitForDrainAction) Kind() ActionKind {
+	return KindWaitForDrain
+}
@@ -0,0 +1,196 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_stopActions_CloneForValidTargets(t *testing.T) {
+	t.Parallel()
+
+	drained := NodeState{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true, CacheWarmed: true, Healthy: true}
+
+	testCases := map[string]struct {
+		activeConnections   int
+		drainTimedOut       bool
+		awaitingConnections bool
+		forceStop           bool
+		expected            []string
+	}{
+		"no connections": {
+			expected: []string{"Stop app: app1-1"},
+		},
+		"connections open": {
+			activeConnections: 5,
+			expected:          []string{"Wait for connections to drain: app1-1"},
+		},
+		"awaiting connections": {
+			awaitingConnections: true,
+			expected:            []string{"Wait for connections to drain: app1-1"},
+		},
+		"awaiting connections, forcing stop": {
+			awaitingConnections: true,
+			forceStop:           true,
+			expected:            []string{"Wait for connections to drain: app1-1"},
+		},
+		"connections open after timeout": {
+			activeConnections: 5,
+			drainTimedOut:     true,
+		},
+		"connections open after timeout, forcing stop": {
+			activeConnections: 5,
+			drainTimedOut:     true,
+			forceStop:         true,
+			expected:          []string{"Stop app: app1-1"},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			nodeState := drained
+			nodeState.ActiveConnections = tc.activeConnections
+			nodeState.DrainTimedOut = tc.drainTimedOut
+			nodeState.AwaitingConnections = tc.awaitingConnections
+			state := State{Nodes: []NodeState{nodeState}}
+
+			prototypes := []MaintenanceAction{
+				&WaitForDrainAction{Goal: Goal{TargetRevision: 2}, Timeout: time.Minute},
+				&StopAppAction{Goal: Goal{TargetRevision: 2}, ForceStop: tc.forceStop},
+			}
+			var actual []string
+			for _, prototype := range prototypes {
+				for _, action := range prototype.CloneForValidTargets(state) {
+					actual = append(actual, action.String())
+					if action.FinalState().Nodes[0].ActiveConnections != 0 {
+						t.Errorf("%s: expected no connections afterwards, got %d", action, action.FinalState().Nodes[0].ActiveConnections)
+					}
+					if action.FinalState().Nodes[0].AwaitingConnections {
+						t.Errorf("%s: expected not to be awaiting connections afterwards", action)
+					}
+				}
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPlanner_drainTimedOut(t *testing.T) {
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:              "app1-1",
+			Cluster:           1,
+			SoftwareRevision:  1,
+			AppRunning:        true,
+			PoolWeight:        0,
+			CacheWarmed:       true,
+			Healthy:           true,
+			ActiveConnections: 3,
+			DrainTimedOut:     true,
+		},
+	}}
+
+	timedOut := TimedOutDrains(startingState, false)
+	if len(timedOut) != 1 || timedOut[0] != "app1-1" {
+		t.Errorf("expected [app1-1] to have timed out, got %v", timedOut)
+	}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{}
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	if plan != nil {
+		t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+	}
+	mp = &Planner{Drain: DrainPolicy{ForceStop: true}}
+	plan = mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+	if len(plan) == 0 || plan[0].String() != "Stop app: app1-1" {
+		t.Errorf("expected the app to be stopped regardless, got:\n%s", maintenanceActionList(plan))
+	}
+}
+
+func TestPlanner_waitForDrainWithTimeout(t *testing.T) {
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+
+	testCases := map[string]struct {
+		planner  *Planner
+		expected []string
+	}{
+		"no timeout": {
+			planner:  &Planner{},
+			expected: []string{"Drain node from pool: app1-1", "Stop app: app1-1"},
+		},
+		"timeout": {
+			planner:  &Planner{Drain: DrainPolicy{Timeout: time.Minute}},
+			expected: []string{"Drain node from pool: app1-1", "Wait for connections to drain: app1-1", "Stop app: app1-1"},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			plan := tc.planner.PlanActionsForTargetRevision(startingState, 2)
+			var actual []string
+			for _, action := range plan {
+				if action.Kind() == KindUpdateSoftwareRevision {
+					break
+				}
+				actual = append(actual, action.String())
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v\n%s", tc.expected, actual, maintenanceActionList(plan))
+			}
+		})
+	}
+}
+
+func ExamplePlanner_waitForDrain() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:              "app1-1",
+			Cluster:           1,
+			SoftwareRevision:  1,
+			AppRunning:        true,
+			PoolWeight:        FullWeight,
+			CacheWarmed:       true,
+			Healthy:           true,
+			ActiveConnections: 40,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{Drain: DrainPolicy{Timeout: 2 * time.Minute}}
+	schedule := mp.PlanScheduleForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for _, sa := range schedule {
+		fmt.Printf("+%s..+%s %s\n", sa.Start, sa.End, sa.Action)
+	}
+	// Output:
+	// +0s..+5s Drain node from pool: app1-1
+	// +5s..+2m5s Wait for connections to drain: app1-1
+	// +2m5s..+2m15s Stop app: app1-1
+	// +2m15s..+3m15s Update software: app1-1
+	// +3m15s..+3m35s Start app: app1-1
+	// +3m35s..+3m50s Health check: app1-1
+	// +3m50s..+13m50s Warm cache: app1-1
+	// +13m50s..+13m55s Add node to pool: app1-1
+}
@@ -0,0 +1,340 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+// A Constraint decides whether moving from one State to the next by way of
+// a given MaintenanceAction is safe. Every candidate action is checked
+// against every active constraint, and discarded if any of them object.
+type Constraint interface {
+	// Name identifies the constraint when explaining a violation.
+	Name() string
+	// Check returns nil if the transition is allowed, or an error describing
+	// why it is not.
+	Check(state State, action MaintenanceAction, nextState State) error
+}
+
+// ConstraintViolation records a Constraint's objection to an action.
+type ConstraintViolation struct {
+	Constraint string
+	Action     MaintenanceAction
+	Err        error
+}
+
+func (cv ConstraintViolation) Error() string {
+	return fmt.Sprintf("%s: violates %s: %s", cv.Action, cv.Constraint, cv.Err)
+}
+
+func checkConstraints(constraints []Constraint, state State, action MaintenanceAction) []ConstraintViolation {
+	var violations []ConstraintViolation
+	for _, constraint := range constraints {
+		err := constraint.Check(state, action, action.FinalState())
+		if err != nil {
+			violations = append(violations, ConstraintViolation{
+				Constraint: constraint.Name(),
+				Action:     action,
+				Err:        err,
+			})
+		}
+	}
+	return violations
+}
+
+// NewConstraint wraps a plain function as a named Constraint, e.g.:
+//
+//	maintenance.NewConstraint("never-drain-app1-1", func(state, action, next) error {
+//		if _, ok := action.(*maintenance.DrainNodeFromPoolAction); ok && action.NodeName() == "app1-1" {
+//			return errors.New("app1-1 must stay in the pool")
+//		}
+//		return nil
+//	})
+func NewConstraint(name string, check func(state State, action MaintenanceAction, nextState State) error) Constraint {
+	return &funcConstraint{
+		name:  name,
+		check: check,
+	}
+}
+
+type funcConstraint struct {
+	name  string
+	check func(state State, action MaintenanceAction, nextState State) error
+}
+
+func (fc *funcConstraint) Name() string {
+	return fc.name
+}
+
+func (fc *funcConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	return fc.check(state, action, nextState)
+}
+
+// OneGroupDownConstraint only allows nodes to be taken down (drained or
+// stopped) in the single "downable" group of nodes sharing a value for Label,
+// and refuses to take any more nodes down once more than one group has nodes
+// down. Groups whose nodes are all waiting, on Dependencies, for Canary
+// nodes to be verified or for capacity to drain them, or which the Filter
+// doesn't allow to be touched, aren't chosen while others can make progress.
+// Nodes out of the pool still count as down, whether or not they may be
+// touched. Failing a cluster's traffic over takes down all of its nodes in
+// the pool at once.
+type OneGroupDownConstraint struct {
+	Goal
+	Label        string
+	Dependencies Dependencies
+	Canary       *CanaryPolicy
+	SafetyFactor float64
+	Filter       NodeFilter
+}
+
+func (ogdc *OneGroupDownConstraint) Name() string {
+	return fmt.Sprintf("one-%s-down", ogdc.Label)
+}
+
+func (ogdc *OneGroupDownConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	nodes := takenDown(state, action)
+	if len(nodes) == 0 {
+		return nil
+	}
+	downableGroup, ok := getDownableGroup(state, ogdc.Label, startGate{Goal: ogdc.Goal, Dependencies: ogdc.Dependencies, Canary: ogdc.Canary, SafetyFactor: ogdc.SafetyFactor, Filter: ogdc.Filter})
+	if !ok {
+		return fmt.Errorf("no %s may be taken down", ogdc.Label)
+	}
+	for _, nodeState := range nodes {
+		if nodeState.Label(ogdc.Label) != downableGroup {
+			return fmt.Errorf("node %s is in %s %q, but only %s %q may be taken down", nodeState.Name, ogdc.Label, nodeState.Label(ogdc.Label), ogdc.Label, downableGroup)
+		}
+	}
+	return nil
+}
+
+// takenDown returns the nodes an action takes down: the node being drained
+// or stopped, or those a cluster failover takes out of the pool.
+func takenDown(state State, action MaintenanceAction) []NodeState {
+	var names []string
+	switch a := action.(type) {
+	case *DrainNodeFromPoolAction, *StopAppAction:
+		names = []string{action.NodeName()}
+	case *FailoverClusterTrafficAction:
+		names = a.drained
+	}
+	var nodes []NodeState
+	for _, name := range names {
+		if i := state.indexOfNode(name); i >= 0 {
+			nodes = append(nodes, state.Nodes[i])
+		}
+	}
+	return nodes
+}
+
+// GroupStepSyncConstraint requires all nodes with the same role sharing a
+// value for Label to reach the same step of their pipeline before any of them
+// moves on to the next one. Nodes which must wait to start, on Dependencies,
+// for Canary nodes to be verified or for capacity to drain them, don't hold
+// the others back, so only as many nodes as capacity allows move together;
+// nor do nodes the Filter doesn't allow to be touched. Failed nodes and their
+// replacements, nodes added by a SurgePolicy, and decommissioning, are exempt
+// altogether. With InFlightOnly set, neither do nodes which haven't started
+// or have already finished maintenance.
+type GroupStepSyncConstraint struct {
+	Goal
+	Label        string
+	Dependencies Dependencies
+	Canary       *CanaryPolicy
+	SafetyFactor float64
+	Filter       NodeFilter
+	InFlightOnly bool
+}
+
+func (gssc *GroupStepSyncConstraint) Name() string {
+	return fmt.Sprintf("%s-step-sync", gssc.Label)
+}
+
+func (gssc *GroupStepSyncConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	i := state.indexOfNode(action.NodeName())
+	if i < 0 || state.Nodes[i].failed() || state.Nodes[i].replacing() || state.Nodes[i].joining() {
+		return nil
+	}
+	switch action.(type) {
+	case *DecommissionNodeAction, *ReloadConfigAction:
+		return nil
+	}
+	groupValue := state.Nodes[i].Label(gssc.Label)
+	role := state.Nodes[i].roleOrDefault()
+	nodeStep := stepNumberForNode(state.Nodes[i], gssc.Goal)
+	lowStep := lowestStepForGroup(state, gssc.Label, groupValue, role, startGate{Goal: gssc.Goal, Dependencies: gssc.Dependencies, Canary: gssc.Canary, SafetyFactor: gssc.SafetyFactor, Filter: gssc.Filter}, gssc.InFlightOnly)
+	if lowStep < nodeStep {
+		return fmt.Errorf("node %s is at step %d, but %s %q still has %s nodes at step %d", state.Nodes[i].Name, nodeStep, gssc.Label, groupValue, role, lowStep)
+	}
+	return nil
+}
+
+// MaxDegradedGroupsConstraint limits how many groups of nodes sharing a value
+// for Label may have nodes out of the load-balancer pool at once, e.g. "at
+// most one zone degraded at a time". If Within is set, the limit applies
+// separately among the groups sharing each value of that label instead, e.g.
+// "at most one cluster degraded per region".
+type MaxDegradedGroupsConstraint struct {
+	Label  string
+	Within string
+	Max    int
+}
+
+func (mdgc *MaxDegradedGroupsConstraint) Name() string {
+	if mdgc.Within != "" {
+		return fmt.Sprintf("max-%d-%s-degraded-per-%s", mdgc.Max, mdgc.Label, mdgc.Within)
+	}
+	return fmt.Sprintf("max-%d-%s-degraded", mdgc.Max, mdgc.Label)
+}
+
+func (mdgc *MaxDegradedGroupsConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	before := mdgc.countDegraded(state)
+	for withinValue, after := range mdgc.countDegraded(nextState) {
+		// don't object to states which were already over the limit, so long
+		// as they aren't getting any worse
+		if after > mdgc.Max && after > before[withinValue] {
+			if mdgc.Within != "" {
+				return fmt.Errorf("%d %s groups in %s %q would be degraded, but at most %d may be", after, mdgc.Label, mdgc.Within, withinValue, mdgc.Max)
+			}
+			return fmt.Errorf("%d %s groups would be degraded, but at most %d may be", after, mdgc.Label, mdgc.Max)
+		}
+	}
+	return nil
+}
+
+// countDegraded counts the degraded groups, by their value for Within.
+func (mdgc *MaxDegradedGroupsConstraint) countDegraded(state State) map[string]int {
+	degraded := make(map[string]map[string]bool)
+	for _, nodeState := range state.Nodes {
+		if !nodeState.down() {
+			continue
+		}
+		withinValue := nodeState.Label(mdgc.Within)
+		if degraded[withinValue] == nil {
+			degraded[withinValue] = make(map[string]bool)
+		}
+		degraded[withinValue][nodeState.Label(mdgc.Label)] = true
+	}
+	counts := make(map[string]int)
+	for withinValue, groups := range degraded {
+		counts[withinValue] = len(groups)
+	}
+	return counts
+}
+
+// RolloutOrderConstraint makes groups of nodes sharing a value for Label
+// start maintenance in the given Order: nodes may only be taken down in a
+// group which is already degraded, or if every group ahead of it is either
+// degraded or finished. Groups missing from Order follow those in it, sorted.
+// If Within is set, only groups sharing a value for that label are ordered
+// relative to each other.
+type RolloutOrderConstraint struct {
+	Goal
+	Label  string
+	Within string
+	Order  []string
+}
+
+func (roc *RolloutOrderConstraint) Name() string {
+	if roc.Within != "" {
+		return fmt.Sprintf("%s-rollout-order-per-%s", roc.Label, roc.Within)
+	}
+	return fmt.Sprintf("%s-rollout-order", roc.Label)
+}
+
+func (roc *RolloutOrderConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	for _, nodeState := range takenDown(state, action) {
+		err := roc.checkNode(state, nodeState)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkNode decides whether the given node's group may start maintenance.
+func (roc *RolloutOrderConstraint) checkNode(state State, candidate NodeState) error {
+	groupValue := candidate.Label(roc.Label)
+	withinValue := candidate.Label(roc.Within)
+
+	degraded := make(map[string]bool)
+	unfinished := make(map[string]bool)
+	for _, nodeState := range state.Nodes {
+		if nodeState.Label(roc.Within) != withinValue {
+			continue
+		}
+		if nodeState.down() {
+			degraded[nodeState.Label(roc.Label)] = true
+		}
+		if (!nodeState.upToDate(roc.Goal) && !nodeState.failed()) || nodeState.down() {
+			unfinished[nodeState.Label(roc.Label)] = true
+		}
+	}
+	if degraded[groupValue] {
+		return nil
+	}
+	for _, earlierValue := range orderLabelValues(roc.Order, unfinished) {
+		if earlierValue == groupValue {
+			return nil
+		}
+		if !degraded[earlierValue] {
+			return fmt.Errorf("%s %q must start maintenance before %s %q", roc.Label, earlierValue, roc.Label, groupValue)
+		}
+	}
+	return nil
+}
+
+// orderLabelValues returns the values from the given set, in the given order;
+// any not mentioned in the order follow, sorted.
+func orderLabelValues(order []string, values map[string]bool) []string {
+	var ordered []string
+	seen := make(map[string]bool)
+	for _, value := range order {
+		if values[value] && !seen[value] {
+			ordered = append(ordered, value)
+			seen[value] = true
+		}
+	}
+	var rest []string
+	for value := range values {
+		if !seen[value] {
+			rest = append(rest, value)
+		}
+	}
+	sortLabelValues(rest)
+	return append(ordered, rest...)
+}
+
+// MaxDownPerGroupConstraint limits how many nodes sharing a value for Label
+// may be out of the load-balancer pool at once, e.g. "never drain two nodes
+// in the same rack".
+type MaxDownPerGroupConstraint struct {
+	Label string
+	Max   int
+}
+
+func (mdpgc *MaxDownPerGroupConstraint) Name() string {
+	return fmt.Sprintf("max-%d-down-per-%s", mdpgc.Max, mdpgc.Label)
+}
+
+func (mdpgc *MaxDownPerGroupConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	before := downNodesByLabel(state, mdpgc.Label)
+	for groupValue, after := range downNodesByLabel(nextState, mdpgc.Label) {
+		if after > mdpgc.Max && after > before[groupValue] {
+			return fmt.Errorf("%d nodes in %s %q would be down, but at most %d may be", after, mdpgc.Label, groupValue, mdpgc.Max)
+		}
+	}
+	return nil
+}
+
+// downNodesByLabel counts the nodes which are down, by their value for the
+// given label.
+func downNodesByLabel(state State, label string) map[string]int {
+	down := make(map[string]int)
+	for _, nodeState := range state.Nodes {
+		if nodeState.down() {
+			down[nodeState.Label(label)] += 1
+		}
+	}
+	return down
+}
@@ -0,0 +1,83 @@
+package maintenance
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestPlanner_Explain(t *testing.T) {
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+		},
+		NodeState{
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+		},
+	}}
+	mp := &Planner{
+		Constraints: []Constraint{
+			NewConstraint("never-drain-app1-1", func(state State, action MaintenanceAction, nextState State) error {
+				if _, ok := action.(*DrainNodeFromPoolAction); ok && action.NodeName() == "app1-1" {
+					return errors.New("app1-1 must stay in the pool")
+				}
+				return nil
+			}),
+		},
+	}
+
+	drains := (&DrainNodeFromPoolAction{Goal: Goal{TargetRevision: 2}}).CloneForValidTargets(startingState)
+	if len(drains) != 2 {
+		t.Fatalf("expected 2 drain actions, got %d", len(drains))
+	}
+
+	testCases := map[string]struct {
+		action   MaintenanceAction
+		expected []string
+	}{
+		"custom constraint": {
+			action:   drains[0],
+			expected: []string{"never-drain-app1-1"},
+		},
+		"built-in constraint": {
+			action:   drains[1],
+			expected: []string{"one-cluster-down"},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			violations := mp.Explain(startingState, tc.action, 2)
+			if len(violations) != len(tc.expected) {
+				t.Fatalf("expected %d violations, got %v", len(tc.expected), violations)
+			}
+			for i, violation := range violations {
+				if violation.Constraint != tc.expected[i] {
+					t.Errorf("expected violation of %q, got %q", tc.expected[i], violation.Constraint)
+				}
+			}
+		})
+	}
+
+	log.SetOutput(ioutil.Discard)
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+	if plan != nil {
+		t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+	}
+	if mp.Rejections()["never-drain-app1-1"] == 0 {
+		t.Errorf("expected rejections by never-drain-app1-1, got %v", mp.Rejections())
+	}
+}
@@ -0,0 +1,169 @@
+package maintenance
+
+import (
+	"fmt"
+	"math"
+)
+
+var knownActionKinds = map[ActionKind]bool{
+	KindDrainNodeFromPool:      true,
+	KindStopApp:                true,
+	KindUpdateSoftwareRevision: true,
+	KindStartApp:               true,
+	KindHealthCheck:            true,
+	KindCatchUpReplication:     true,
+	KindWarmCache:              true,
+	KindAddNodeToPool:          true,
+	KindExpandSchema:           true,
+	KindContractSchema:         true,
+	KindPauseForVerification:   true,
+	KindRemoveFromPool:         true,
+	KindMarkForReplacement:     true,
+	KindProvisionReplacement:   true,
+	KindProvisionNode:          true,
+	KindDecommissionNode:       true,
+	KindPatchOS:                true,
+	KindRebootNode:             true,
+	KindUpdateConfig:           true,
+	KindReloadConfig:           true,
+	KindRampWeight:             true,
+	KindWaitForDrain:           true,
+	KindFailoverClusterTraffic: true,
+	KindRestoreClusterTraffic:  true,
+}
+
+// CostModel describes what the planner should minimise. The zero value
+// charges 1 for every action, i.e. it minimises the number of actions.
+type CostModel struct {
+	// ByKind is the base cost of each kind of action; kinds not listed
+	// cost 1.
+	ByKind map[ActionKind]float64 `yaml:"bykind"`
+	// NodeMultipliers scale the cost of every action on a node, keyed by
+	// node name.
+	NodeMultipliers map[string]float64 `yaml:"nodemultipliers"`
+	// ClusterMultipliers scale the cost of every action on nodes in a
+	// cluster, or on the cluster as a whole, keyed by cluster number.
+	ClusterMultipliers map[int]float64 `yaml:"clustermultipliers"`
+	// OutOfPoolPenalty is charged on every action, once for each node which
+	// is out of the load-balancer pool after that action.
+	OutOfPoolPenalty float64 `yaml:"outofpoolpenalty"`
+	// MixedRevisionStepPenalty is charged on every action after which nodes
+	// in the load-balancer pool are running more than one revision, so that
+	// plans keep mixed-revision windows short. It counts steps rather than
+	// time: a long action costs no more than a short one, so it only
+	// approximates the MixedRevisionTime reported for a plan, which is
+	// measured with Durations.
+	MixedRevisionStepPenalty float64 `yaml:"mixedrevisionsteppenalty"`
+
+	// byNode overrides ByKind for individual nodes, keyed by node name; it's
+	// only set on cost models derived from Durations.
+	byNode map[string]map[ActionKind]float64
+}
+
+// Validate rejects cost models which would make the planner's heuristic
+// inadmissible, i.e. allow it to overestimate the remaining cost of a plan,
+// and therefore produce sub-optimal plans.
+//
+// The heuristic charges each step a node has left at that step's configured
+// cost, or with a SurgePolicy the lesser of that and replacing the node, and
+// ignores OutOfPoolPenalty and MixedRevisionStepPenalty entirely, so it's
+// admissible so long as no cost, multiplier or penalty is negative.
+func (cm CostModel) Validate() error {
+	for kind, cost := range cm.ByKind {
+		if !knownActionKinds[kind] {
+			return fmt.Errorf("unknown action kind %q", kind)
+		}
+		if err := checkNonNegative(cost); err != nil {
+			return fmt.Errorf("cost for %q: %s", kind, err)
+		}
+	}
+	for name, byKind := range cm.byNode {
+		for kind, cost := range byKind {
+			if err := checkNonNegative(cost); err != nil {
+				return fmt.Errorf("cost for %q on node %q: %s", kind, name, err)
+			}
+		}
+	}
+	for name, multiplier := range cm.NodeMultipliers {
+		if err := checkNonNegative(multiplier); err != nil {
+			return fmt.Errorf("multiplier for node %q: %s", name, err)
+		}
+	}
+	for clusterNum, multiplier := range cm.ClusterMultipliers {
+		if err := checkNonNegative(multiplier); err != nil {
+			return fmt.Errorf("multiplier for cluster %d: %s", clusterNum, err)
+		}
+	}
+	if err := checkNonNegative(cm.OutOfPoolPenalty); err != nil {
+		return fmt.Errorf("out-of-pool penalty: %s", err)
+	}
+	if err := checkNonNegative(cm.MixedRevisionStepPenalty); err != nil {
+		return fmt.Errorf("mixed-revision step penalty: %s", err)
+	}
+	return nil
+}
+
+func checkNonNegative(f float64) error {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return fmt.Errorf("%f is not a finite number", f)
+	}
+	if f < 0 {
+		return fmt.Errorf("%f is negative", f)
+	}
+	return nil
+}
+
+// Cost returns what it costs to take an action, including the penalties for
+// nodes left out of the pool, and for mixed revisions in the pool, afterwards.
+func (cm CostModel) Cost(action MaintenanceAction) float64 {
+	finalState := action.FinalState()
+
+	var cost float64
+	i := finalState.indexOfNode(action.NodeName())
+	if i >= 0 {
+		cost += cm.costForNode(finalState.Nodes[i], action.Kind())
+	} else if ca, ok := action.(clusterAction); ok {
+		cost += cm.costForCluster(ca.targetCluster(), action.Kind())
+	} else {
+		// fleet-wide actions aren't subject to node or cluster multipliers
+		cost += cm.costForKind(action.Kind())
+	}
+	for _, nodeState := range finalState.Nodes {
+		if !nodeState.inPool() {
+			cost += cm.OutOfPoolPenalty
+		}
+	}
+	if cm.MixedRevisionStepPenalty > 0 && mixedRevisions(finalState) {
+		cost += cm.MixedRevisionStepPenalty
+	}
+	return cost
+}
+
+func (cm CostModel) costForKind(kind ActionKind) float64 {
+	if kindCost, found := cm.ByKind[kind]; found {
+		return kindCost
+	}
+	return 1.0
+}
+
+func (cm CostModel) costForCluster(cluster int, kind ActionKind) float64 {
+	cost := cm.costForKind(kind)
+	if multiplier, found := cm.ClusterMultipliers[cluster]; found {
+		cost *= multiplier
+	}
+	return cost
+}
+
+func (cm CostModel) costForNode(nodeState NodeState, kind ActionKind) float64 {
+	cost := cm.costForKind(kind)
+	if nodeCost, found := cm.byNode[nodeState.Name][kind]; found {
+		cost = nodeCost
+	}
+	if multiplier, found := cm.NodeMultipliers[nodeState.Name]; found {
+		cost *= multiplier
+	}
+	if multiplier, found := cm.ClusterMultipliers[nodeState.Cluster]; found {
+		cost *= multiplier
+	}
+	return cost
+}
@@ -0,0 +1,145 @@
+package maintenance
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCostModel_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		costs       CostModel
+		expectError bool
+	}{
+		"zero value": {
+			costs: CostModel{},
+		},
+		"fully populated": {
+			costs: CostModel{
+				ByKind:                   map[ActionKind]float64{KindWarmCache: 3},
+				NodeMultipliers:          map[string]float64{"app1-1": 2},
+				ClusterMultipliers:       map[int]float64{1: 0.5},
+				OutOfPoolPenalty:         0.25,
+				MixedRevisionStepPenalty: 1,
+			},
+		},
+		"unknown kind": {
+			costs: CostModel{
+				ByKind: map[ActionKind]float64{"reticulatesplines": 1},
+			},
+			expectError: true,
+		},
+		"negative kind cost": {
+			costs: CostModel{
+				ByKind: map[ActionKind]float64{KindWarmCache: -1},
+			},
+			expectError: true,
+		},
+		"negative node multiplier": {
+			costs: CostModel{
+				NodeMultipliers: map[string]float64{"app1-1": -2},
+			},
+			expectError: true,
+		},
+		"infinite cluster multiplier": {
+			costs: CostModel{
+				ClusterMultipliers: map[int]float64{1: math.Inf(1)},
+			},
+			expectError: true,
+		},
+		"negative penalty": {
+			costs: CostModel{
+				OutOfPoolPenalty: -0.1,
+			},
+			expectError: true,
+		},
+		"negative mixed-revision step penalty": {
+			costs: CostModel{
+				MixedRevisionStepPenalty: -1,
+			},
+			expectError: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			err := tc.costs.Validate()
+			if tc.expectError && err == nil {
+				t.Error("expected an error, got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+		})
+	}
+}
+
+func Test_baseEstimateForNode(t *testing.T) {
+	t.Parallel()
+
+	costs := CostModel{
+		ByKind:             map[ActionKind]float64{KindWarmCache: 10},
+		ClusterMultipliers: map[int]float64{2: 2},
+	}
+
+	testCases := map[string]struct {
+		nodeState NodeState
+		expected  float64
+	}{
+		"full cycle": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 1,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
+			},
+			expected: 16,
+		},
+		"full cycle with multiplier": {
+			nodeState: NodeState{
+				Name:             "app2-1",
+				Cluster:          2,
+				SoftwareRevision: 1,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
+			},
+			expected: 32,
+		},
+		"only needs adding to pool": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       0,
+				CacheWarmed:      true,
+				Healthy:          true,
+			},
+			expected: 1,
+		},
+		"needs health check before adding to pool": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       0,
+				CacheWarmed:      true,
+			},
+			expected: 2,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			actual := baseEstimateForNode(tc.nodeState, Goal{TargetRevision: 2}, costs)
+			if actual != tc.expected {
+				t.Errorf("expected %f, got %f", tc.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+// A Dependency declares that nodes in one group may only move to a revision
+// once every node in another group is at that revision or later, e.g. app
+// nodes may move to revision 2 only after all db nodes are at revision 2:
+//
+//	Dependency{Label: RoleLabel, Group: "app", DependsOn: "db"}
+type Dependency struct {
+	// Label is the node label whose values name the groups.
+	Label string
+	// Group is the dependent group.
+	Group string
+	// DependsOn is the group which must be updated first.
+	DependsOn string
+}
+
+func (d Dependency) String() string {
+	return fmt.Sprintf("%s %q depends on %s %q", d.Label, d.Group, d.Label, d.DependsOn)
+}
+
+// Dependencies are enforced as preconditions on UpdateSoftwareRevisionAction.
+// Nodes whose dependencies aren't yet met aren't drained either, so that the
+// planner takes down the groups they depend on first rather than leaving
+// them waiting out of the pool.
+type Dependencies []Dependency
+
+// Validate rejects incomplete dependencies, and cycles which would leave no
+// group free to go first.
+func (ds Dependencies) Validate() error {
+	edges := make(map[string][]string)
+	for _, d := range ds {
+		if d.Label == "" || d.Group == "" || d.DependsOn == "" {
+			return fmt.Errorf("incomplete dependency: %+v", d)
+		}
+		if d.Group == d.DependsOn {
+			return fmt.Errorf("%s: a group can't depend on itself", d)
+		}
+		from := d.Label + "=" + d.Group
+		edges[from] = append(edges[from], d.Label+"="+d.DependsOn)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make(map[string]int)
+	var visit func(group string) error
+	visit = func(group string) error {
+		switch marks[group] {
+		case visiting:
+			return fmt.Errorf("dependency cycle through %s", group)
+		case visited:
+			return nil
+		}
+		marks[group] = visiting
+		for _, next := range edges[group] {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		marks[group] = visited
+		return nil
+	}
+	for _, d := range ds {
+		if err := visit(d.Label + "=" + d.Group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmetFor returns the first of the node's dependencies not yet satisfied in
+// the given state, or nil if they all are. Failed nodes, which are replaced
+// rather than upgraded, don't hold their dependents back.
+func (ds Dependencies) unmetFor(state State, nodeState NodeState, targetRevision int) *Dependency {
+	for i, d := range ds {
+		if nodeState.Label(d.Label) != d.Group {
+			continue
+		}
+		for _, other := range state.Nodes {
+			if other.failed() || other.retired() {
+				continue
+			}
+			if other.Label(d.Label) == d.DependsOn && other.SoftwareRevision < targetRevision {
+				return &ds[i]
+			}
+		}
+	}
+	return nil
+}
+
+// outOfReach returns the names of nodes which others in the filter's reach
+// depend on, but which are themselves out of it and behind the target
+// revision, so would hold their dependents back for good.
+func (ds Dependencies) outOfReach(state State, filter NodeFilter, targetRevision int) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, nodeState := range state.Nodes {
+		if !filter.Allows(nodeState) || nodeState.failed() || nodeState.SoftwareRevision >= targetRevision {
+			continue
+		}
+		for _, d := range ds {
+			if nodeState.Label(d.Label) != d.Group {
+				continue
+			}
+			for _, other := range state.Nodes {
+				if filter.Allows(other) || other.failed() || other.retired() || seen[other.Name] {
+					continue
+				}
+				if other.Label(d.Label) == d.DependsOn && other.SoftwareRevision < targetRevision {
+					names = append(names, other.Name)
+					seen[other.Name] = true
+				}
+			}
+		}
+	}
+	return names
+}
@@ -0,0 +1,215 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestDependencies_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		dependencies Dependencies
+		expectErr    bool
+	}{
+		"none": {},
+		"chain": {
+			dependencies: Dependencies{
+				{Label: RoleLabel, Group: "app", DependsOn: "cache"},
+				{Label: RoleLabel, Group: "cache", DependsOn: "stateful"},
+			},
+		},
+		"incomplete": {
+			dependencies: Dependencies{
+				{Group: "app", DependsOn: "stateful"},
+			},
+			expectErr: true,
+		},
+		"self": {
+			dependencies: Dependencies{
+				{Label: RoleLabel, Group: "app", DependsOn: "app"},
+			},
+			expectErr: true,
+		},
+		"cycle": {
+			dependencies: Dependencies{
+				{Label: RoleLabel, Group: "app", DependsOn: "cache"},
+				{Label: RoleLabel, Group: "cache", DependsOn: "stateful"},
+				{Label: RoleLabel, Group: "stateful", DependsOn: "app"},
+			},
+			expectErr: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			err := tc.dependencies.Validate()
+			if tc.expectErr && err == nil {
+				t.Error("expected an error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestUpdateSoftwareRevisionAction_dependencies(t *testing.T) {
+	t.Parallel()
+
+	dependencies := Dependencies{
+		{Label: RoleLabel, Group: "app", DependsOn: "stateful"},
+	}
+	testCases := map[string]struct {
+		dbRevision int
+		dbHealth   HealthStatus
+		expected   int
+	}{
+		"db behind": {
+			dbRevision: 1,
+			expected:   0,
+		},
+		"db at target": {
+			dbRevision: 2,
+			expected:   1,
+		},
+		"db ahead": {
+			dbRevision: 3,
+			expected:   1,
+		},
+		"db behind but failed": {
+			dbRevision: 1,
+			dbHealth:   HealthFailed,
+			expected:   1,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			state := State{Nodes: []NodeState{
+				NodeState{
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 1,
+				},
+				NodeState{
+					Name:             "db2-1",
+					Cluster:          2,
+					Role:             "stateful",
+					SoftwareRevision: tc.dbRevision,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					Healthy:          true,
+					Health:           tc.dbHealth,
+				},
+			}}
+			prototype := &UpdateSoftwareRevisionAction{Goal: Goal{TargetRevision: 2}, Dependencies: dependencies}
+			actual := prototype.CloneForValidTargets(state)
+			if len(actual) != tc.expected {
+				t.Errorf("expected %d actions, got %d: %v", tc.expected, len(actual), actual)
+			}
+		})
+	}
+}
+
+func TestPlanner_dependencies(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, cluster int, role string) NodeState {
+		return NodeState{Name: name, Cluster: cluster, Role: role, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true}
+	}
+	testCases := map[string]struct {
+		planner   Planner
+		dbHealth  HealthStatus
+		expectNil bool
+	}{
+		"db failed": {
+			dbHealth: HealthFailed,
+		},
+		"db frozen": {
+			planner:   Planner{Frozen: NodeSelector{Names: []string{"db2-1"}}},
+			expectNil: true,
+		},
+		"db out of scope": {
+			planner:   Planner{Scope: &NodeSelector{Clusters: []int{1}}},
+			expectNil: true,
+		},
+		"app frozen": {
+			planner: Planner{Frozen: NodeSelector{Clusters: []int{1}}},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			db := node("db2-1", 2, "stateful")
+			db.Health = tc.dbHealth
+			state := State{Nodes: []NodeState{node("app1-1", 1, ""), node("app1-2", 1, ""), db}}
+			mp := tc.planner
+			mp.Dependencies = Dependencies{{Label: RoleLabel, Group: "app", DependsOn: "stateful"}}
+			plan := mp.PlanActionsForTargetRevision(state, 2)
+			if tc.expectNil && plan != nil {
+				t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+			}
+			if !tc.expectNil && len(plan) == 0 {
+				t.Errorf("expected a plan, got none")
+			}
+		})
+	}
+}
+
+func ExamplePlanner_dependencies() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "db2-1",
+			Cluster:          2,
+			Role:             "stateful",
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			Healthy:          true,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{
+		Dependencies: Dependencies{
+			{Label: RoleLabel, Group: "app", DependsOn: "stateful"},
+		},
+	}
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for _, action := range plan {
+		fmt.Println(action)
+	}
+	// Output:
+	// Drain node from pool: db2-1
+	// Stop app: db2-1
+	// Update software: db2-1
+	// Start app: db2-1
+	// Health check: db2-1
+	// Catch up replication: db2-1
+	// Add node to pool: db2-1
+	// Drain node from pool: app1-1
+	// Stop app: app1-1
+	// Update software: app1-1
+	// Start app: app1-1
+	// Health check: app1-1
+	// Warm cache: app1-1
+	// Add node to pool: app1-1
+}
@@ -0,0 +1,287 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	KindFailoverClusterTraffic ActionKind = "failoverclustertraffic"
+	KindRestoreClusterTraffic  ActionKind = "restoreclustertraffic"
+)
+
+// TrafficState records which clusters a global load balancer has stopped
+// sending traffic to.
+type TrafficState struct {
+	// FailedOver lists, in order, the clusters whose traffic has been
+	// failed over to the rest of the fleet.
+	FailedOver []int `yaml:"failedover,omitempty"`
+}
+
+func (ts TrafficState) failedOver(cluster int) bool {
+	for _, c := range ts.FailedOver {
+		if c == cluster {
+			return true
+		}
+	}
+	return false
+}
+
+// withFailedOver returns a copy of the TrafficState with the given cluster
+// failed over, or restored.
+func (ts TrafficState) withFailedOver(cluster int, failedOver bool) TrafficState {
+	var clusters []int
+	for _, c := range ts.FailedOver {
+		if c != cluster {
+			clusters = append(clusters, c)
+		}
+	}
+	if failedOver {
+		clusters = append(clusters, cluster)
+		sort.Ints(clusters)
+	}
+	return TrafficState{FailedOver: clusters}
+}
+
+// servedDemand returns the State's Demand as the pool must serve it. A
+// cluster whose traffic has been failed over serves nothing, so the rest of
+// the pool must serve its demand on top of their own, as well as the global
+// demand.
+func (s State) servedDemand() Demand {
+	if len(s.Traffic.FailedOver) == 0 {
+		return s.Demand
+	}
+	demand := Demand{Global: s.Demand.Global}
+	for cluster, clusterDemand := range s.Demand.ByCluster {
+		demand.Global += clusterDemand
+		if s.Traffic.failedOver(cluster) {
+			continue
+		}
+		if demand.ByCluster == nil {
+			demand.ByCluster = make(map[int]float64)
+		}
+		demand.ByCluster[cluster] = clusterDemand
+	}
+	return demand
+}
+
+// A clusterAction acts on a whole cluster, rather than on a single node.
+type clusterAction interface {
+	MaintenanceAction
+	targetCluster() int
+}
+
+// FailoverClusterTrafficAction has a global load balancer send a cluster's
+// traffic to the rest of the fleet, taking all of the cluster's nodes out of
+// the pool at once rather than draining them one at a time. Only clusters
+// with a node due to be drained are failed over, and only once every node in
+// the pool there may be touched, none of those due to be drained is waiting
+// on Dependencies, and the Canary nodes have been verified. Their nodes don't
+// rejoin the pool until a RestoreClusterTrafficAction.
+type FailoverClusterTrafficAction struct {
+	Goal
+	Dependencies Dependencies
+	Canary       *CanaryPolicy
+	Filter       NodeFilter
+	cluster      int
+	drained      []string
+	finalState   State
+}
+
+func (fcta *FailoverClusterTrafficAction) String() string {
+	return fmt.Sprintf("Fail over cluster traffic: cluster %d", fcta.cluster)
+}
+
+func (fcta *FailoverClusterTrafficAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	if fcta.Canary != nil && !startingState.Canary.verified(fcta.Goal) {
+		return nil
+	}
+
+	due := make(map[int]bool)
+	blocked := make(map[int]bool)
+	for _, nodeState := range startingState.Nodes {
+		if startingState.Traffic.failedOver(nodeState.Cluster) {
+			continue
+		}
+		if nodeState.serving() && !fcta.Filter.Allows(nodeState) {
+			blocked[nodeState.Cluster] = true
+			continue
+		}
+		if !fcta.Filter.Allows(nodeState) || nextKindForNode(nodeState, fcta.Goal) != KindDrainNodeFromPool {
+			continue
+		}
+		if fcta.Dependencies.unmetFor(startingState, nodeState, fcta.TargetRevision) != nil {
+			blocked[nodeState.Cluster] = true
+			continue
+		}
+		due[nodeState.Cluster] = true
+	}
+	clusters := make([]int, 0, len(due))
+	for cluster := range due {
+		if !blocked[cluster] {
+			clusters = append(clusters, cluster)
+		}
+	}
+	sort.Ints(clusters)
+
+	var out []MaintenanceAction
+	for _, cluster := range clusters {
+		newState := startingState
+		newState.Nodes = make([]NodeState, len(startingState.Nodes))
+		copy(newState.Nodes, startingState.Nodes)
+		var drained []string
+		for i, nodeState := range newState.Nodes {
+			// failed nodes are taken out of the pool by RemoveFromPoolAction
+			if nodeState.Cluster == cluster && nodeState.serving() {
+				newState.Nodes[i].PoolWeight = 0
+				drained = append(drained, nodeState.Name)
+			}
+		}
+		newState.Traffic = startingState.Traffic.withFailedOver(cluster, true)
+
+		out = append(out, &FailoverClusterTrafficAction{
+			Goal:         fcta.Goal,
+			Dependencies: fcta.Dependencies,
+			Canary:       fcta.Canary,
+			Filter:       fcta.Filter,
+			cluster:      cluster,
+			drained:      drained,
+			finalState:   newState,
+		})
+	}
+	return out
+}
+
+func (fcta *FailoverClusterTrafficAction) FinalState() State {
+	return fcta.finalState
+}
+
+func (fcta *FailoverClusterTrafficAction) NodeName() string {
+	return ""
+}
+
+func (fcta *FailoverClusterTrafficAction) Kind() ActionKind {
+	return KindFailoverClusterTraffic
+}
+
+func (fcta *FailoverClusterTrafficAction) targetCluster() int {
+	return fcta.cluster
+}
+
+// RestoreClusterTrafficAction has a global load balancer send traffic back
+// to a cluster which was failed over, once every node there the Filter
+// allows is ready to rejoin the pool. They all rejoin at once, at
+// FullWeight.
+type RestoreClusterTrafficAction struct {
+	Goal
+	Filter     NodeFilter
+	cluster    int
+	restored   []string
+	finalState State
+}
+
+func (rcta *RestoreClusterTrafficAction) String() string {
+	return fmt.Sprintf("Restore cluster traffic: cluster %d", rcta.cluster)
+}
+
+func (rcta *RestoreClusterTrafficAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for _, cluster := range startingState.Traffic.FailedOver {
+		ready := true
+		var restored []int
+		for i, nodeState := range startingState.Nodes {
+			if nodeState.Cluster != cluster || nodeState.failed() || !rcta.Filter.Allows(nodeState) {
+				continue
+			}
+			switch nextKindForNode(nodeState, rcta.Goal) {
+			case "":
+			case KindAddNodeToPool:
+				restored = append(restored, i)
+			default:
+				ready = false
+			}
+		}
+		if !ready {
+			continue
+		}
+
+		newState := startingState
+		newState.Nodes = make([]NodeState, len(startingState.Nodes))
+		copy(newState.Nodes, startingState.Nodes)
+		var names []string
+		for _, i := range restored {
+			newState.Nodes[i].PoolWeight = FullWeight
+			names = append(names, newState.Nodes[i].Name)
+		}
+		newState.Traffic = startingState.Traffic.withFailedOver(cluster, false)
+
+		out = append(out, &RestoreClusterTrafficAction{
+			Goal:       rcta.Goal,
+			Filter:     rcta.Filter,
+			cluster:    cluster,
+			restored:   names,
+			finalState: newState,
+		})
+	}
+	return out
+}
+
+func (rcta *RestoreClusterTrafficAction) FinalState() State {
+	return rcta.finalState
+}
+
+func (rcta *RestoreClusterTrafficAction) NodeName() string {
+	return ""
+}
+
+func (rcta *RestoreClusterTrafficAction) Kind() ActionKind {
+	return KindRestoreClusterTraffic
+}
+
+func (rcta *RestoreClusterTrafficAction) targetCluster() int {
+	return rcta.cluster
+}
+
+// estimateFailover adjusts the estimated cost of a State for clusters which
+// may be, or have been, failed over. A failed-over cluster's nodes rejoin
+// the pool by restoring its traffic, rather than one at a time; if allowed
+// is set, any other cluster may be failed over and restored in place of
+// draining and re-adding its nodes, whichever costs less.
+func estimateFailover(state State, goal Goal, rampStep int, costs CostModel, filter NodeFilter, allowed bool) float64 {
+	rejoining := make(map[int]float64)
+	rolling := make(map[int]float64)
+	for _, nodeState := range state.Nodes {
+		if !filter.Allows(nodeState) || nodeState.failed() {
+			continue
+		}
+		var cost float64
+		if kindNeededForNode(KindAddNodeToPool, nodeState, goal) {
+			cost += costs.costForNode(nodeState, KindAddNodeToPool)
+		}
+		cost += estimateRampForNode(nodeState, goal, rampStep, costs)
+		rejoining[nodeState.Cluster] += cost
+		if kindNeededForNode(KindDrainNodeFromPool, nodeState, goal) {
+			cost += costs.costForNode(nodeState, KindDrainNodeFromPool)
+		}
+		rolling[nodeState.Cluster] += cost
+	}
+
+	var adjustment float64
+	for cluster, cost := range rolling {
+		restore := costs.costForCluster(cluster, KindRestoreClusterTraffic)
+		if state.Traffic.failedOver(cluster) {
+			adjustment += restore - rejoining[cluster]
+			continue
+		}
+		if failover := costs.costForCluster(cluster, KindFailoverClusterTraffic) + restore; allowed && failover < cost {
+			adjustment += failover - cost
+		}
+	}
+	// clusters left without any nodes still need restoring
+	for _, cluster := range state.Traffic.FailedOver {
+		if _, found := rolling[cluster]; !found {
+			adjustment += costs.costForCluster(cluster, KindRestoreClusterTraffic)
+		}
+	}
+	return adjustment
+}
@@ -0,0 +1,325 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestFailoverClusterTrafficAction_CloneForValidTargets(t *testing.T) {
+	t.Parallel()
+
+	old := func(name string, cluster int) NodeState {
+		return NodeState{Name: name, Cluster: cluster, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true}
+	}
+	updated := NodeState{Name: "app2-1", Cluster: 2, SoftwareRevision: 2, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true}
+
+	testCases := map[string]struct {
+		state    State
+		frozen   NodeSelector
+		canary   *CanaryPolicy
+		expected []string
+		drained  []string
+	}{
+		"every cluster due": {
+			state: State{Nodes: []NodeState{old("app1-1", 1), old("app1-2", 1), old("app2-1", 2)}},
+			expected: []string{
+				"Fail over cluster traffic: cluster 1",
+				"Fail over cluster traffic: cluster 2",
+			},
+			drained: []string{"app1-1", "app1-2"},
+		},
+		"cluster up to date": {
+			state:    State{Nodes: []NodeState{old("app1-1", 1), updated}},
+			expected: []string{"Fail over cluster traffic: cluster 1"},
+			drained:  []string{"app1-1"},
+		},
+		"up to date nodes go with their cluster": {
+			state:    State{Nodes: []NodeState{updated, old("app2-2", 2)}},
+			expected: []string{"Fail over cluster traffic: cluster 2"},
+			drained:  []string{"app2-1", "app2-2"},
+		},
+		"already failed over": {
+			state: State{
+				Nodes:   []NodeState{old("app1-1", 1)},
+				Traffic: TrafficState{FailedOver: []int{1}},
+			},
+		},
+		"frozen node in the pool": {
+			state:    State{Nodes: []NodeState{old("app1-1", 1), old("app1-2", 1), old("app2-1", 2)}},
+			frozen:   NodeSelector{Names: []string{"app1-2"}},
+			expected: []string{"Fail over cluster traffic: cluster 2"},
+			drained:  []string{"app2-1"},
+		},
+		"canaries unverified": {
+			state:  State{Nodes: []NodeState{old("app1-1", 1)}},
+			canary: &CanaryPolicy{},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			prototype := &FailoverClusterTrafficAction{
+				Goal:   Goal{TargetRevision: 2},
+				Canary: tc.canary,
+				Filter: NodeFilter{Frozen: tc.frozen},
+			}
+			actions := prototype.CloneForValidTargets(tc.state)
+			var actual []string
+			for _, action := range actions {
+				actual = append(actual, action.String())
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+			if len(actions) == 0 {
+				return
+			}
+			var drained []string
+			for _, nodeState := range actions[0].FinalState().Nodes {
+				if !nodeState.inPool() {
+					drained = append(drained, nodeState.Name)
+				}
+			}
+			if fmt.Sprint(drained) != fmt.Sprint(tc.drained) {
+				t.Errorf("expected %v out of the pool, got %v", tc.drained, drained)
+			}
+		})
+	}
+}
+
+func TestRestoreClusterTrafficAction_CloneForValidTargets(t *testing.T) {
+	t.Parallel()
+
+	ready := NodeState{Name: "app1-1", Cluster: 1, SoftwareRevision: 2, AppRunning: true, CacheWarmed: true, Healthy: true}
+	warming := NodeState{Name: "app1-2", Cluster: 1, SoftwareRevision: 2, AppRunning: true, Healthy: true}
+	failed := NodeState{Name: "app1-3", Cluster: 1, SoftwareRevision: 1, Health: HealthFailed}
+
+	testCases := map[string]struct {
+		state    State
+		expected []string
+	}{
+		"ready": {
+			state: State{
+				Nodes:   []NodeState{ready, failed},
+				Traffic: TrafficState{FailedOver: []int{1}},
+			},
+			expected: []string{"Restore cluster traffic: cluster 1"},
+		},
+		"still warming": {
+			state: State{
+				Nodes:   []NodeState{ready, warming},
+				Traffic: TrafficState{FailedOver: []int{1}},
+			},
+		},
+		"not failed over": {
+			state: State{Nodes: []NodeState{ready}},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			prototype := &RestoreClusterTrafficAction{Goal: Goal{TargetRevision: 2}}
+			actions := prototype.CloneForValidTargets(tc.state)
+			var actual []string
+			for _, action := range actions {
+				actual = append(actual, action.String())
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, actual)
+			}
+			for _, action := range actions {
+				finalState := action.FinalState()
+				if len(finalState.Traffic.FailedOver) != 0 {
+					t.Errorf("expected no clusters failed over, got %v", finalState.Traffic.FailedOver)
+				}
+				if nodeState := finalState.Nodes[finalState.indexOfNode("app1-1")]; nodeState.PoolWeight != FullWeight {
+					t.Errorf("expected app1-1 at weight %d, got %d", FullWeight, nodeState.PoolWeight)
+				}
+			}
+		})
+	}
+}
+
+func TestCapacityConstraint_Check_failover(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, cluster int) NodeState {
+		return NodeState{Name: name, Cluster: cluster, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true, Capacity: 100}
+	}
+	nodes := []NodeState{node("app1-1", 1), node("app1-2", 1), node("app2-1", 2), node("app2-2", 2)}
+
+	testCases := map[string]struct {
+		demand      Demand
+		expectError bool
+	}{
+		"rest of the pool can take it": {
+			demand: Demand{Global: 200},
+		},
+		"short of global capacity": {
+			demand:      Demand{Global: 300},
+			expectError: true,
+		},
+		"cluster's own demand moves with it": {
+			demand:      Demand{Global: 150, ByCluster: map[int]float64{1: 100}},
+			expectError: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			state := State{Nodes: nodes, Demand: tc.demand}
+			prototype := &FailoverClusterTrafficAction{Goal: Goal{TargetRevision: 2}}
+			actions := prototype.CloneForValidTargets(state)
+			if len(actions) == 0 {
+				t.Fatal("expected an action to check, got none")
+			}
+			cc := &CapacityConstraint{Goal: Goal{TargetRevision: 2}}
+			err := cc.Check(state, actions[0], actions[0].FinalState())
+			if tc.expectError && err == nil {
+				t.Error("expected an error, got nil")
+			}
+			if !tc.expectError && err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestPlanner_clusterFailoverCost(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, cluster int) NodeState {
+		return NodeState{Name: name, Cluster: cluster, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true}
+	}
+	startingState := State{Nodes: []NodeState{node("app1-1", 1), node("app1-2", 1), node("app2-1", 2)}}
+
+	testCases := map[string]struct {
+		costs    CostModel
+		expected ActionKind
+	}{
+		"failover cheaper than draining": {
+			expected: KindFailoverClusterTraffic,
+		},
+		"failover dearer than draining": {
+			costs:    CostModel{ByKind: map[ActionKind]float64{KindFailoverClusterTraffic: 10}},
+			expected: KindDrainNodeFromPool,
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			mp := &Planner{ClusterFailover: true, Costs: tc.costs}
+			plan := mp.PlanActionsForTargetRevision(startingState, 2)
+			if len(plan) == 0 {
+				t.Fatal("expected a plan, got none")
+			}
+			if plan[0].Kind() != tc.expected {
+				t.Errorf("expected to start with %q, got %s", tc.expected, plan[0])
+			}
+			finalState := plan[len(plan)-1].FinalState()
+			if len(finalState.Traffic.FailedOver) != 0 {
+				t.Errorf("expected every cluster restored, got %v failed over", finalState.Traffic.FailedOver)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_clusterFailover() {
+	log.SetFlags(0)
+	node := func(name string, cluster int) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+			Capacity:         100,
+		}
+	}
+	startingState := State{
+		Nodes: []NodeState{
+			node("app1-1", 1),
+			node("app1-2", 1),
+			node("app1-3", 1),
+			node("app2-1", 2),
+			node("app2-2", 2),
+			node("app2-3", 2),
+		},
+		Demand: Demand{Global: 250},
+	}
+
+	// either cluster can serve all of the demand while the other is failed
+	// over, which saves draining and re-adding each node
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{ClusterFailover: true}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Fail over cluster traffic: cluster 1
+	// Stage 2:
+	//     Stop app: app1-1
+	//     Stop app: app1-2
+	//     Stop app: app1-3
+	// Stage 3:
+	//     Update software: app1-1
+	//     Update software: app1-2
+	//     Update software: app1-3
+	// Stage 4:
+	//     Start app: app1-1
+	//     Start app: app1-2
+	//     Start app: app1-3
+	// Stage 5:
+	//     Health check: app1-1
+	//     Health check: app1-2
+	//     Health check: app1-3
+	// Stage 6:
+	//     Warm cache: app1-1
+	//     Warm cache: app1-2
+	//     Warm cache: app1-3
+	// Stage 7:
+	//     Restore cluster traffic: cluster 1
+	// Stage 8:
+	//     Fail over cluster traffic: cluster 2
+	// Stage 9:
+	//     Stop app: app2-1
+	//     Stop app: app2-2
+	//     Stop app: app2-3
+	// Stage 10:
+	//     Update software: app2-1
+	//     Update software: app2-2
+	//     Update software: app2-3
+	// Stage 11:
+	//     Start app: app2-1
+	//     Start app: app2-2
+	//     Start app: app2-3
+	// Stage 12:
+	//     Health check: app2-1
+	//     Health check: app2-2
+	//     Health check: app2-3
+	// Stage 13:
+	//     Warm cache: app2-1
+	//     Warm cache: app2-2
+	//     Warm cache: app2-3
+	// Stage 14:
+	//     Restore cluster traffic: cluster 2
+}
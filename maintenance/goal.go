@@ -0,0 +1,20 @@
+package maintenance
+
+// A Goal is what a rollout brings every node to: the target software
+// revision, OS patch level and configuration, by way of the Pipeline for
+// the node's role.
+type Goal struct {
+	TargetRevision int
+	OSPatchLevel   int
+	Config         ConfigChange
+	Pipelines      Pipelines
+}
+
+func (p *Planner) goal(targetSoftwareRevision int) Goal {
+	return Goal{
+		TargetRevision: targetSoftwareRevision,
+		OSPatchLevel:   p.OSPatchLevel,
+		Config:         p.Config,
+		Pipelines:      p.Pipelines,
+	}
+}
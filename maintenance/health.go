@@ -0,0 +1,222 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+// HealthStatus records how well a node is working, as observed outside the
+// rollout; it's distinct from Healthy, which tracks the rollout's own health
+// check. The empty value means HealthHealthy.
+type HealthStatus string
+
+const (
+	HealthHealthy HealthStatus = "healthy"
+	// HealthDegraded nodes still serve traffic, and are upgraded as usual.
+	HealthDegraded HealthStatus = "degraded"
+	// HealthFailed nodes can't serve traffic. Rather than being upgraded,
+	// they're removed from the pool and replaced.
+	HealthFailed HealthStatus = "failed"
+)
+
+const (
+	KindRemoveFromPool       ActionKind = "removefrompool"
+	KindMarkForReplacement   ActionKind = "markforreplacement"
+	KindProvisionReplacement ActionKind = "provisionreplacement"
+)
+
+func (ns NodeState) failed() bool {
+	return ns.Health == HealthFailed
+}
+
+// retired reports whether a failed node has been replaced, after which it
+// no longer counts towards the fleet's availability at all.
+func (ns NodeState) retired() bool {
+	return ns.ReplacedBy != ""
+}
+
+// replacing reports whether a node is a replacement which hasn't yet joined
+// the pool.
+func (ns NodeState) replacing() bool {
+	return ns.Replaces != "" && !ns.inPool()
+}
+
+// serving reports whether a node is in the pool and able to serve traffic.
+func (ns NodeState) serving() bool {
+	return ns.inPool() && !ns.failed()
+}
+
+// down reports whether a node counts as unavailable: out of the pool, or
+// failed and not yet replaced. Nodes which have been replaced, or which the
+// rollout has added and haven't joined the pool yet, aren't missed.
+func (ns NodeState) down() bool {
+	return !ns.retired() && !ns.joining() && !ns.serving()
+}
+
+// replacementName names the node provisioned to replace the given one.
+func replacementName(name string) string {
+	return name + "-replacement"
+}
+
+// estimateReplacement returns the cost of the actions a failed node still
+// needs before it's replaced.
+func estimateReplacement(nodeState NodeState, costs CostModel) float64 {
+	var cost float64
+	if nodeState.retired() {
+		return cost
+	}
+	if nodeState.inPool() {
+		cost += costs.costForNode(nodeState, KindRemoveFromPool)
+	}
+	if !nodeState.MarkedForReplacement {
+		cost += costs.costForNode(nodeState, KindMarkForReplacement)
+	}
+	return cost + costs.costForNode(nodeState, KindProvisionReplacement)
+}
+
+// RemoveFromPoolAction takes a failed node out of the load-balancer pool.
+// There's no draining it, since it isn't serving anything.
+type RemoveFromPoolAction struct {
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (rfpa *RemoveFromPoolAction) String() string {
+	return fmt.Sprintf("Remove failed node from pool: %s", rfpa.nodeName)
+}
+
+func (rfpa *RemoveFromPoolAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !rfpa.Filter.Allows(nodeState) || !nodeState.failed() || !nodeState.inPool() {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.PoolWeight = 0
+
+		out = append(out, &RemoveFromPoolAction{
+			Filter:     rfpa.Filter,
+			nodeName:   nodeState.Name,
+			finalState: startingState.withNode(i, newNodeState),
+		})
+	}
+	return out
+}
+
+func (rfpa *RemoveFromPoolAction) FinalState() State {
+	return rfpa.finalState
+}
+
+func (rfpa *RemoveFromPoolAction) NodeName() string {
+	return rfpa.nodeName
+}
+
+func (rfpa *RemoveFromPoolAction) Kind() ActionKind {
+	return KindRemoveFromPool
+}
+
+// MarkForReplacementAction records that a failed node, once out of the
+// pool, is to be replaced rather than repaired.
+type MarkForReplacementAction struct {
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (mfra *MarkForReplacementAction) String() string {
+	return fmt.Sprintf("Mark for replacement: %s", mfra.nodeName)
+}
+
+func (mfra *MarkForReplacementAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !mfra.Filter.Allows(nodeState) || !nodeState.failed() || nodeState.inPool() || nodeState.MarkedForReplacement {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.MarkedForReplacement = true
+
+		out = append(out, &MarkForReplacementAction{
+			Filter:     mfra.Filter,
+			nodeName:   nodeState.Name,
+			finalState: startingState.withNode(i, newNodeState),
+		})
+	}
+	return out
+}
+
+func (mfra *MarkForReplacementAction) FinalState() State {
+	return mfra.finalState
+}
+
+func (mfra *MarkForReplacementAction) NodeName() string {
+	return mfra.nodeName
+}
+
+func (mfra *MarkForReplacementAction) Kind() ActionKind {
+	return KindMarkForReplacement
+}
+
+// ProvisionReplacementAction brings up a new node in place of one marked for
+// replacement, already running the target revision, OS patch level and
+// configuration. The new node then goes through the rest of its pipeline,
+// from the health check onwards, before it joins the pool; it needn't keep
+// in step with the rest of its group.
+type ProvisionReplacementAction struct {
+	Goal
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (pra *ProvisionReplacementAction) String() string {
+	return fmt.Sprintf("Provision replacement for: %s", pra.nodeName)
+}
+
+func (pra *ProvisionReplacementAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !pra.Filter.Allows(nodeState) || !nodeState.MarkedForReplacement || nodeState.retired() {
+			continue
+		}
+		replacement := NodeState{
+			Name:             replacementName(nodeState.Name),
+			Cluster:          nodeState.Cluster,
+			SoftwareRevision: pra.TargetRevision,
+			OSPatchLevel:     newOSPatchLevel(nodeState, pra.OSPatchLevel),
+			ConfigVersion:    newConfigVersion(nodeState, pra.Config),
+			AppRunning:       true,
+			Role:             nodeState.Role,
+			Capacity:         nodeState.Capacity,
+			Labels:           nodeState.Labels,
+			Replaces:         nodeState.Name,
+		}
+		if startingState.indexOfNode(replacement.Name) >= 0 {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.ReplacedBy = replacement.Name
+
+		newState := startingState.withNode(i, newNodeState)
+		newState.Nodes = append(newState.Nodes, replacement)
+		out = append(out, &ProvisionReplacementAction{
+			Goal:       pra.Goal,
+			Filter:     pra.Filter,
+			nodeName:   nodeState.Name,
+			finalState: newState,
+		})
+	}
+	return out
+}
+
+func (pra *ProvisionReplacementAction) FinalState() State {
+	return pra.finalState
+}
+
+func (pra *ProvisionReplacementAction) NodeName() string {
+	return pra.nodeName
+}
+
+func (pra *ProvisionReplacementAction) Kind() ActionKind {
+	return KindProvisionReplacement
+}
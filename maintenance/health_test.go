@@ -0,0 +1,128 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestNodeState_down(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		nodeState NodeState
+		expected  bool
+	}{
+		"in pool": {
+			nodeState: NodeState{PoolWeight: FullWeight},
+		},
+		"degraded in pool": {
+			nodeState: NodeState{PoolWeight: FullWeight, Health: HealthDegraded},
+		},
+		"out of pool": {
+			nodeState: NodeState{},
+			expected:  true,
+		},
+		"failed in pool": {
+			nodeState: NodeState{PoolWeight: FullWeight, Health: HealthFailed},
+			expected:  true,
+		},
+		"failed and replaced": {
+			nodeState: NodeState{Health: HealthFailed, MarkedForReplacement: true, ReplacedBy: "app1-1-replacement"},
+		},
+		"surge node joining": {
+			nodeState: NodeState{Provisioned: true},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if actual := tc.nodeState.down(); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestGroupStepSyncConstraint_failedNode(t *testing.T) {
+	t.Parallel()
+
+	// app1-2 is dead, stuck at step 0, and mustn't hold app1-1 back
+	state := State{Nodes: []NodeState{
+		{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true, CacheWarmed: true, Healthy: true},
+		{Name: "app1-2", Cluster: 1, SoftwareRevision: 1, PoolWeight: FullWeight, Health: HealthFailed},
+	}}
+	prototype := &StopAppAction{Goal: Goal{TargetRevision: 2}}
+	actions := prototype.CloneForValidTargets(state)
+	if len(actions) != 1 {
+		t.Fatalf("expected 1 action, got %d", len(actions))
+	}
+	gssc := &GroupStepSyncConstraint{Goal: Goal{TargetRevision: 2}, Label: ClusterLabel}
+	err := gssc.Check(state, actions[0], actions[0].FinalState())
+	if err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+}
+
+func ExamplePlanner_failedNode() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			PoolWeight:       FullWeight,
+			Health:           HealthFailed,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Drain node from pool: app1-1
+	// Stage 2:
+	//     Stop app: app1-1
+	// Stage 3:
+	//     Update software: app1-1
+	// Stage 4:
+	//     Start app: app1-1
+	// Stage 5:
+	//     Health check: app1-1
+	// Stage 6:
+	//     Warm cache: app1-1
+	// Stage 7:
+	//     Add node to pool: app1-1
+	// Stage 8:
+	//     Remove failed node from pool: app1-2
+	// Stage 9:
+	//     Mark for replacement: app1-2
+	// Stage 10:
+	//     Provision replacement for: app1-2
+	// Stage 11:
+	//     Health check: app1-2-replacement
+	// Stage 12:
+	//     Warm cache: app1-2-replacement
+	// Stage 13:
+	//     Add node to pool: app1-2-replacement
+}
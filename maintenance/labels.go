@@ -0,0 +1,44 @@
+package maintenance
+
+import (
+	"sort"
+	"strconv"
+)
+
+// ClusterLabel is the label under which a node's Cluster is exposed, so that
+// policies can group nodes by cluster just as they would by any other label.
+const ClusterLabel = "cluster"
+
+// RoleLabel is the label under which a node's Role is exposed; nodes with no
+// Role have DefaultRole.
+const RoleLabel = "role"
+
+// Label returns the node's value for the given label, or an empty string if
+// it has none.
+func (ns NodeState) Label(key string) string {
+	if key == ClusterLabel {
+		return strconv.Itoa(ns.Cluster)
+	}
+	if key == RoleLabel {
+		return ns.roleOrDefault()
+	}
+	return ns.Labels[key]
+}
+
+// sortLabelValues sorts label values numerically where they're both
+// numbers, e.g. so that cluster "2" sorts before cluster "10", and
+// lexically otherwise.
+func sortLabelValues(values []string) {
+	sort.Slice(values, func(i, j int) bool {
+		return lessLabelValue(values[i], values[j])
+	})
+}
+
+func lessLabelValue(a, b string) bool {
+	aNum, aErr := strconv.Atoi(a)
+	bNum, bErr := strconv.Atoi(b)
+	if aErr == nil && bErr == nil {
+		return aNum < bNum
+	}
+	return a < b
+}
@@ -0,0 +1,162 @@
+package maintenance
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestNodeState_labelsSurviveYAML(t *testing.T) {
+	t.Parallel()
+
+	expected := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Labels: map[string]string{
+				"zone": "us-east-1a",
+				"rack": "r12",
+			},
+		},
+	}}
+	outBytes, err := yaml.Marshal(expected)
+	if err != nil {
+		t.Fatalf("yaml.Marshal: %s", err)
+	}
+	var actual State
+	err = yaml.Unmarshal(outBytes, &actual)
+	if err != nil {
+		t.Fatalf("yaml.Unmarshal: %s", err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+	if actual.Nodes[0].Label(ClusterLabel) != "1" {
+		t.Errorf("expected cluster label %q, got %q", "1", actual.Nodes[0].Label(ClusterLabel))
+	}
+}
+
+func TestPlanner_topologyConstraints(t *testing.T) {
+	node := func(name, zone, rack string) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+			Labels: map[string]string{
+				"zone": zone,
+				"rack": rack,
+			},
+		}
+	}
+	startingState := State{Nodes: []NodeState{
+		node("app1-1", "a", "r1"),
+		node("app1-2", "a", "r1"),
+		node("app1-3", "b", "r2"),
+		node("app1-4", "b", "r3"),
+	}}
+
+	testCases := map[string]struct {
+		planner *Planner
+		label   string
+		max     int
+	}{
+		"never two down in the same rack": {
+			planner: &Planner{
+				LooseStepSync: true,
+				Constraints: []Constraint{
+					&MaxDownPerGroupConstraint{Label: "rack", Max: 1},
+				},
+			},
+			label: "rack",
+			max:   1,
+		},
+		"one zone degraded at a time": {
+			planner: &Planner{
+				LooseStepSync: true,
+				Constraints: []Constraint{
+					&MaxDegradedGroupsConstraint{Label: "zone", Max: 1},
+				},
+			},
+			label: "zone",
+			max:   1,
+		},
+		"group by zone": {
+			planner: &Planner{
+				GroupBy: "zone",
+			},
+			label: "zone",
+			max:   1,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			log.SetOutput(ioutil.Discard)
+			plan := tc.planner.PlanActionsForTargetRevision(startingState, 2)
+			log.SetOutput(os.Stdout)
+			if plan == nil {
+				t.Fatal("expected a plan, got nil")
+			}
+
+			for _, action := range plan {
+				down := downNodesByLabel(action.FinalState(), tc.label)
+				if tc.label == "rack" {
+					for rack, count := range down {
+						if count > tc.max {
+							t.Errorf("after %q, %d nodes down in rack %q", action, count, rack)
+						}
+					}
+				} else if len(down) > tc.max {
+					t.Errorf("after %q, %d zones degraded", action, len(down))
+				}
+			}
+		})
+	}
+}
+
+func TestPlanner_groupByUnlabelledNodes(t *testing.T) {
+	node := func(name string, labels map[string]string) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+			Labels:           labels,
+		}
+	}
+	startingState := State{Nodes: []NodeState{
+		node("app1-1", map[string]string{"zone": "a"}),
+		node("app1-2", nil),
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	plan := (&Planner{GroupBy: "zone"}).PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	upgraded := make(map[string]bool)
+	for _, action := range plan {
+		if action.Kind() == KindUpdateSoftwareRevision {
+			upgraded[action.NodeName()] = true
+		}
+	}
+	for _, nodeState := range startingState.Nodes {
+		if !upgraded[nodeState.Name] {
+			t.Errorf("expected %s to be upgraded", nodeState.Name)
+		}
+	}
+}
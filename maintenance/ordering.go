@@ -0,0 +1,114 @@
+package maintenance
+
+import (
+	"sort"
+)
+
+// A NodeOrder decides which nodes the planner takes first, wherever it has a
+// choice between equally cheap plans, e.g. among the nodes of the group
+// which may be taken down. It never makes a plan cost more, or lets the
+// planner consider any plan it wouldn't otherwise; the order in which
+// groups are taken down is still up to the constraints.
+//
+// Nodes picked by Canaries, if it's set, are taken first; then nodes are
+// taken by descending Priority, then by their value for Label, if it's set,
+// and finally by name.
+type NodeOrder struct {
+	// Canaries, if set, takes the nodes it picks ahead of any others, as a
+	// Planner's CanaryPolicy would, but without pausing for them to be
+	// verified.
+	Canaries *CanaryPolicy `yaml:"canaries"`
+	// Label, if set, orders nodes by their value for that label: in the
+	// order of LabelValues, then, for values it doesn't list, numerically
+	// or lexically.
+	Label       string   `yaml:"label"`
+	LabelValues []string `yaml:"labelvalues"`
+}
+
+// less reports whether the node a should be taken before the node b.
+func (no NodeOrder) less(state State, a, b NodeState) bool {
+	if no.Canaries != nil {
+		aCanary, bCanary := no.Canaries.isCanary(state, a), no.Canaries.isCanary(state, b)
+		if aCanary != bCanary {
+			return aCanary
+		}
+	}
+	if a.Priority != b.Priority {
+		return a.Priority > b.Priority
+	}
+	if no.Label != "" {
+		aValue, bValue := a.Label(no.Label), b.Label(no.Label)
+		if aValue != bValue {
+			aPos, bPos := no.labelValuePosition(aValue), no.labelValuePosition(bValue)
+			if aPos != bPos {
+				return aPos < bPos
+			}
+			return lessLabelValue(aValue, bValue)
+		}
+	}
+	return a.Name < b.Name
+}
+
+// labelValuePosition returns the position of the value in LabelValues, or
+// its length if it isn't listed.
+func (no NodeOrder) labelValuePosition(value string) int {
+	for i, listed := range no.LabelValues {
+		if listed == value {
+			return i
+		}
+	}
+	return len(no.LabelValues)
+}
+
+// nodeRanks orders the nodes of a State, ranking each node by its position
+// and each cluster by the position of its first node.
+type nodeRanks struct {
+	nodes    map[string]int
+	clusters map[int]int
+}
+
+func (no NodeOrder) ranks(state State) nodeRanks {
+	nodes := make([]NodeState, len(state.Nodes))
+	copy(nodes, state.Nodes)
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return no.less(state, nodes[i], nodes[j])
+	})
+
+	nr := nodeRanks{
+		nodes:    make(map[string]int),
+		clusters: make(map[int]int),
+	}
+	for i, nodeState := range nodes {
+		nr.nodes[nodeState.Name] = i
+		if _, found := nr.clusters[nodeState.Cluster]; !found {
+			nr.clusters[nodeState.Cluster] = i
+		}
+	}
+	return nr
+}
+
+// rank returns the rank of the node or cluster an action targets. Actions
+// on neither, or on nodes added since the ranks were taken, come last.
+func (nr nodeRanks) rank(action MaintenanceAction) int {
+	if ca, ok := action.(clusterAction); ok {
+		if rank, found := nr.clusters[ca.targetCluster()]; found {
+			return rank
+		}
+		return len(nr.nodes)
+	}
+	if rank, found := nr.nodes[action.NodeName()]; found {
+		return rank
+	}
+	return len(nr.nodes)
+}
+
+// rankStep ranks an action taken after another: actions of the same kind
+// come first, so that equally cheap plans batch them into stages, and then
+// by the rank of their node.
+func (nr nodeRanks) rankStep(previous, action MaintenanceAction) int {
+	rank := nr.rank(action)
+	if previous.Kind() != action.Kind() {
+		rank += len(nr.nodes) + 1
+	}
+	return rank
+}
@@ -0,0 +1,192 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestNodeOrder_ranks(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, cluster, priority int, zone string) NodeState {
+		return NodeState{Name: name, Cluster: cluster, Priority: priority, Labels: map[string]string{"zone": zone}}
+	}
+	state := State{Nodes: []NodeState{
+		node("app2-1", 2, 0, "b"),
+		node("app1-2", 1, 0, "a"),
+		node("app1-1", 1, 0, "c"),
+		node("app3-1", 3, 5, "b"),
+	}}
+
+	testCases := map[string]struct {
+		order    NodeOrder
+		expected []string
+	}{
+		"priority then name": {
+			expected: []string{"app3-1", "app1-1", "app1-2", "app2-1"},
+		},
+		"by label": {
+			order:    NodeOrder{Label: "zone"},
+			expected: []string{"app3-1", "app1-2", "app2-1", "app1-1"},
+		},
+		"listed label values first": {
+			order:    NodeOrder{Label: "zone", LabelValues: []string{"c"}},
+			expected: []string{"app3-1", "app1-1", "app1-2", "app2-1"},
+		},
+		"canaries first": {
+			order:    NodeOrder{Canaries: &CanaryPolicy{Nodes: []string{"app2-1"}}},
+			expected: []string{"app2-1", "app3-1", "app1-1", "app1-2"},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			ranks := tc.order.ranks(state)
+			actual := make([]string, len(state.Nodes))
+			for name, rank := range ranks.nodes {
+				actual[rank] = name
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPlanner_order(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, cluster, priority int) NodeState {
+		return NodeState{Name: name, Cluster: cluster, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true, Capacity: 100, Priority: priority}
+	}
+
+	testCases := map[string]struct {
+		nodes    []NodeState
+		order    NodeOrder
+		expected []string
+	}{
+		"by name": {
+			nodes:    []NodeState{node("app1-3", 1, 0), node("app1-2", 1, 0), node("app1-1", 1, 0)},
+			expected: []string{"app1-1", "app1-2", "app1-3"},
+		},
+		"by priority": {
+			nodes:    []NodeState{node("app1-3", 1, 0), node("app1-2", 1, 1), node("app1-1", 1, 0)},
+			expected: []string{"app1-2", "app1-1", "app1-3"},
+		},
+		"canaries first": {
+			nodes:    []NodeState{node("app1-3", 1, 0), node("app1-2", 1, 1), node("app1-1", 1, 0)},
+			order:    NodeOrder{Canaries: &CanaryPolicy{Nodes: []string{"app1-3"}}},
+			expected: []string{"app1-3", "app1-2", "app1-1"},
+		},
+		"groups still in order": {
+			nodes:    []NodeState{node("app2-1", 2, 1), node("app1-2", 1, 0), node("app1-1", 1, 0)},
+			expected: []string{"app1-1", "app1-2", "app2-1"},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			// only one node may be drained at a time
+			state := State{Nodes: tc.nodes, Demand: Demand{Global: 100 * float64(len(tc.nodes)-1)}}
+			mp := &Planner{Order: tc.order}
+			plan := mp.PlanActionsForTargetRevision(state, 2)
+			var drained []string
+			for _, action := range plan {
+				if action.Kind() == KindDrainNodeFromPool {
+					drained = append(drained, action.NodeName())
+				}
+			}
+			if fmt.Sprint(drained) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected nodes drained in order %v, got %v", tc.expected, drained)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_order() {
+	log.SetFlags(0)
+	node := func(name, rack string) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+			Capacity:         100,
+			Labels:           map[string]string{"rack": rack},
+		}
+	}
+	startingState := State{
+		Nodes: []NodeState{
+			node("app1-1", "b"),
+			node("app1-2", "a"),
+			node("app1-3", "b"),
+			node("app1-4", "a"),
+		},
+		Demand: Demand{Global: 200},
+	}
+
+	// two nodes may be drained at once; take rack "a" first
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{Order: NodeOrder{Label: "rack"}}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Drain node from pool: app1-2
+	//     Drain node from pool: app1-4
+	// Stage 2:
+	//     Stop app: app1-2
+	//     Stop app: app1-4
+	// Stage 3:
+	//     Update software: app1-2
+	//     Update software: app1-4
+	// Stage 4:
+	//     Start app: app1-2
+	//     Start app: app1-4
+	// Stage 5:
+	//     Health check: app1-2
+	//     Health check: app1-4
+	// Stage 6:
+	//     Warm cache: app1-2
+	//     Warm cache: app1-4
+	// Stage 7:
+	//     Add node to pool: app1-2
+	//     Add node to pool: app1-4
+	// Stage 8:
+	//     Drain node from pool: app1-1
+	//     Drain node from pool: app1-3
+	// Stage 9:
+	//     Stop app: app1-1
+	//     Stop app: app1-3
+	// Stage 10:
+	//     Update software: app1-1
+	//     Update software: app1-3
+	// Stage 11:
+	//     Start app: app1-1
+	//     Start app: app1-3
+	// Stage 12:
+	//     Health check: app1-1
+	//     Health check: app1-3
+	// Stage 13:
+	//     Warm cache: app1-1
+	//     Warm cache: app1-3
+	// Stage 14:
+	//     Add node to pool: app1-1
+	//     Add node to pool: app1-3
+}
@@ -0,0 +1,128 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+const (
+	KindPatchOS    ActionKind = "patchos"
+	KindRebootNode ActionKind = "rebootnode"
+)
+
+// osUpToDate reports whether a node's operating system is at or beyond the
+// given patch level, and running it.
+func (ns NodeState) osUpToDate(osPatchLevel int) bool {
+	return ns.OSPatchLevel >= osPatchLevel && !ns.NeedsReboot
+}
+
+// upToDate reports whether a node needs no maintenance to reach the target
+// revision, OS patch level and configuration, other than perhaps to rejoin
+// the pool or to hot-reload its configuration.
+func (ns NodeState) upToDate(goal Goal) bool {
+	return ns.SoftwareRevision == goal.TargetRevision && ns.osUpToDate(goal.OSPatchLevel) && (goal.Config.HotReload || ns.configUpToDate(goal.Config))
+}
+
+// newOSPatchLevel returns the OS patch level of a node provisioned in place
+// of, or as a copy of, the given one: the target, or the old node's if it's
+// further ahead.
+func newOSPatchLevel(nodeState NodeState, osPatchLevel int) int {
+	if nodeState.OSPatchLevel > osPatchLevel {
+		return nodeState.OSPatchLevel
+	}
+	return osPatchLevel
+}
+
+// PatchOSAction brings a stopped node's operating system up to OSPatchLevel.
+// The patch only takes effect once the node is rebooted.
+type PatchOSAction struct {
+	Goal
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (poa *PatchOSAction) String() string {
+	return fmt.Sprintf("Patch OS: %s", poa.nodeName)
+}
+
+func (poa *PatchOSAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !poa.Filter.Allows(nodeState) {
+			continue
+		}
+		if nextKindForNode(nodeState, poa.Goal) != KindPatchOS {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.OSPatchLevel = poa.OSPatchLevel
+		newNodeState.NeedsReboot = true
+
+		out = append(out, &PatchOSAction{
+			Goal:       poa.Goal,
+			Filter:     poa.Filter,
+			nodeName:   nodeState.Name,
+			finalState: startingState.withNode(i, newNodeState),
+		})
+	}
+	return out
+}
+
+func (poa *PatchOSAction) FinalState() State {
+	return poa.finalState
+}
+
+func (poa *PatchOSAction) NodeName() string {
+	return poa.nodeName
+}
+
+func (poa *PatchOSAction) Kind() ActionKind {
+	return KindPatchOS
+}
+
+// RebootNodeAction reboots a stopped node which needs it, e.g. to run a
+// newly patched kernel.
+type RebootNodeAction struct {
+	Goal
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (rna *RebootNodeAction) String() string {
+	return fmt.Sprintf("Reboot node: %s", rna.nodeName)
+}
+
+func (rna *RebootNodeAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !rna.Filter.Allows(nodeState) {
+			continue
+		}
+		if nextKindForNode(nodeState, rna.Goal) != KindRebootNode {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.NeedsReboot = false
+
+		out = append(out, &RebootNodeAction{
+			Goal:       rna.Goal,
+			Filter:     rna.Filter,
+			nodeName:   nodeState.Name,
+			finalState: startingState.withNode(i, newNodeState),
+		})
+	}
+	return out
+}
+
+func (rna *RebootNodeAction) FinalState() State {
+	return rna.finalState
+}
+
+func (rna *RebootNodeAction) NodeName() string {
+	return rna.nodeName
+}
+
+func (rna *RebootNodeAction) Kind() ActionKind {
+	return KindRebootNode
+}
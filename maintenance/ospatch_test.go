@@ -0,0 +1,146 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func Test_kindNeededForNode_osPatch(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		nodeState NodeState
+		expected  []ActionKind
+	}{
+		"up to date": {
+			nodeState: NodeState{SoftwareRevision: 2, OSPatchLevel: 3, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true},
+		},
+		"old revision and patch level": {
+			nodeState: NodeState{SoftwareRevision: 1, OSPatchLevel: 2, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true},
+			expected:  []ActionKind{KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindPatchOS, KindRebootNode, KindStartApp, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+		"old patch level only": {
+			nodeState: NodeState{SoftwareRevision: 2, OSPatchLevel: 2, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true},
+			expected:  []ActionKind{KindDrainNodeFromPool, KindStopApp, KindPatchOS, KindRebootNode, KindStartApp, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+		"needs reboot only": {
+			nodeState: NodeState{SoftwareRevision: 2, OSPatchLevel: 3, NeedsReboot: true, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true},
+			expected:  []ActionKind{KindDrainNodeFromPool, KindStopApp, KindRebootNode, KindStartApp, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+		"patched, awaiting reboot": {
+			nodeState: NodeState{SoftwareRevision: 2, OSPatchLevel: 3, NeedsReboot: true},
+			expected:  []ActionKind{KindRebootNode, KindStartApp, KindHealthCheck, KindWarmCache, KindAddNodeToPool},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			var actual []ActionKind
+			for _, kind := range DefaultPipelines()[DefaultRole] {
+				if kindNeededForNode(kind, tc.nodeState, Goal{TargetRevision: 2, OSPatchLevel: 3}) {
+					actual = append(actual, kind)
+				}
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_osPatch() {
+	log.SetFlags(0)
+	// app1-1 needs both a new revision and an OS patch, app1-2 only the
+	// patch, and app2-1 only a reboot
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			OSPatchLevel:     1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 2,
+			OSPatchLevel:     1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 2,
+			OSPatchLevel:     2,
+			NeedsReboot:      true,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{OSPatchLevel: 2}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Drain node from pool: app1-1
+	//     Drain node from pool: app1-2
+	// Stage 2:
+	//     Stop app: app1-1
+	//     Stop app: app1-2
+	// Stage 3:
+	//     Update software: app1-1
+	// Stage 4:
+	//     Patch OS: app1-1
+	//     Patch OS: app1-2
+	// Stage 5:
+	//     Reboot node: app1-1
+	//     Reboot node: app1-2
+	// Stage 6:
+	//     Start app: app1-1
+	//     Start app: app1-2
+	// Stage 7:
+	//     Health check: app1-1
+	//     Health check: app1-2
+	// Stage 8:
+	//     Warm cache: app1-1
+	//     Warm cache: app1-2
+	// Stage 9:
+	//     Add node to pool: app1-1
+	//     Add node to pool: app1-2
+	// Stage 10:
+	//     Drain node from pool: app2-1
+	// Stage 11:
+	//     Stop app: app2-1
+	// Stage 12:
+	//     Reboot node: app2-1
+	// Stage 13:
+	//     Start app: app2-1
+	// Stage 14:
+	//     Health check: app2-1
+	// Stage 15:
+	//     Warm cache: app2-1
+	// Stage 16:
+	//     Add node to pool: app2-1
+}
@@ -0,0 +1,301 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultRole is the role of any node which doesn't specify one.
+const DefaultRole = "app"
+
+// A Pipeline is the ordered list of actions a node goes through during
+// maintenance. Every pipeline must drain, stop, update, start and re-add its
+// nodes, in that order; any other steps go between starting and re-adding,
+// except for updating the config, patching the OS and rebooting. Those go
+// between updating and starting, in that order, and are added there if a
+// pipeline doesn't list them.
+type Pipeline []ActionKind
+
+// Pipelines maps node roles to the Pipeline for nodes with that role.
+type Pipelines map[string]Pipeline
+
+// DefaultPipelines returns the built-in pipelines:
+//   - "app" nodes have their health checked and their cache warmed before
+//     re-entering the pool.
+//   - "cache" nodes have their health checked, but need no warming.
+//   - "stateful" nodes have their health checked, and must let replication
+//     catch up before re-entering the pool.
+func DefaultPipelines() Pipelines {
+	return Pipelines{
+		"app": {
+			KindDrainNodeFromPool,
+			KindStopApp,
+			KindUpdateSoftwareRevision,
+			KindUpdateConfig,
+			KindPatchOS,
+			KindRebootNode,
+			KindStartApp,
+			KindHealthCheck,
+			KindWarmCache,
+			KindAddNodeToPool,
+		},
+		"cache": {
+			KindDrainNodeFromPool,
+			KindStopApp,
+			KindUpdateSoftwareRevision,
+			KindUpdateConfig,
+			KindPatchOS,
+			KindRebootNode,
+			KindStartApp,
+			KindHealthCheck,
+			KindAddNodeToPool,
+		},
+		"stateful": {
+			KindDrainNodeFromPool,
+			KindStopApp,
+			KindUpdateSoftwareRevision,
+			KindUpdateConfig,
+			KindPatchOS,
+			KindRebootNode,
+			KindStartApp,
+			KindHealthCheck,
+			KindCatchUpReplication,
+			KindAddNodeToPool,
+		},
+	}
+}
+
+// For returns the pipeline for the node's role, falling back first to
+// DefaultPipelines and then to the pipeline for DefaultRole.
+func (ps Pipelines) For(nodeState NodeState) Pipeline {
+	role := nodeState.roleOrDefault()
+	if pipeline, found := ps[role]; found {
+		return pipeline.withImplicitSteps()
+	}
+	defaults := DefaultPipelines()
+	if pipeline, found := defaults[role]; found {
+		return pipeline
+	}
+	if pipeline, found := ps[DefaultRole]; found {
+		return pipeline.withImplicitSteps()
+	}
+	return defaults[DefaultRole]
+}
+
+// implicitSteps follow the software update in every pipeline, in this order.
+var implicitSteps = []ActionKind{KindUpdateConfig, KindPatchOS, KindRebootNode}
+
+// withImplicitSteps returns the pipeline with any implicitSteps it doesn't
+// list added after the step they follow.
+func (p Pipeline) withImplicitSteps() Pipeline {
+	out := p
+	prev := KindUpdateSoftwareRevision
+	for _, kind := range implicitSteps {
+		if out.indexOf(kind) < 0 {
+			i := out.indexOf(prev)
+			if i < 0 {
+				// Validate will reject it
+				return p
+			}
+			withKind := make(Pipeline, 0, len(out)+1)
+			withKind = append(withKind, out[:i+1]...)
+			withKind = append(withKind, kind)
+			out = append(withKind, out[i+1:]...)
+		}
+		prev = kind
+	}
+	return out
+}
+
+func (p Pipeline) indexOf(kind ActionKind) int {
+	for i, k := range p {
+		if k == kind {
+			return i
+		}
+	}
+	return -1
+}
+
+// Validate rejects pipelines which couldn't bring a node to the target
+// revision and back into the pool.
+func (ps Pipelines) Validate() error {
+	roles := make([]string, 0, len(ps))
+	for role := range ps {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	required := []ActionKind{
+		KindDrainNodeFromPool,
+		KindStopApp,
+		KindUpdateSoftwareRevision,
+		KindUpdateConfig,
+		KindPatchOS,
+		KindRebootNode,
+		KindStartApp,
+	}
+	for _, role := range roles {
+		pipeline := ps[role].withImplicitSteps()
+		if len(pipeline) < len(required)+1 {
+			return fmt.Errorf("pipeline for role %q is too short", role)
+		}
+		for i, kind := range required {
+			if pipeline[i] != kind {
+				return fmt.Errorf("pipeline for role %q: step %d must be %q, not %q", role, i, kind, pipeline[i])
+			}
+		}
+		if pipeline[len(pipeline)-1] != KindAddNodeToPool {
+			return fmt.Errorf("pipeline for role %q must end with %q", role, KindAddNodeToPool)
+		}
+		seen := make(map[ActionKind]bool)
+		for _, kind := range pipeline {
+			if !knownActionKinds[kind] {
+				return fmt.Errorf("pipeline for role %q: unknown action kind %q", role, kind)
+			}
+			if seen[kind] {
+				return fmt.Errorf("pipeline for role %q: %q appears more than once", role, kind)
+			}
+			seen[kind] = true
+		}
+	}
+	return nil
+}
+
+func (ns NodeState) roleOrDefault() string {
+	if ns.Role == "" {
+		return DefaultRole
+	}
+	return ns.Role
+}
+
+// stepNumberForNode returns the index, within the node's pipeline, of the
+// next action it needs, or the length of the pipeline if it needs none.
+// For the default "app" pipeline, steps are these:
+// 0- maintenance not started; node is in LB pool
+// 1- maintenance started; node removed from LB pool
+// 2- app stopped
+// 3- software updated
+// 4- config updated
+// 5- OS patched
+// 6- node rebooted
+// 7- app started
+// 8- app passed health check
+// 9- cache warmed
+// 10- maintenance complete; node added back to LB pool, at full weight
+//
+// A node whose only maintenance is a hot-reloadable config change goes
+// straight to step 3, and stays in the LB pool while it's reloaded.
+//
+// Note that we're discarding invalid states here, e.g. the app isn't running
+// but it's in the LB pool is treated as step 0/10; if treated as step 0 it'll
+// end up correctly skipping stopping the app anyway. Likewise a node already
+// in the LB pool isn't made to pass a health check before warming its cache.
+func stepNumberForNode(nodeState NodeState, goal Goal) int {
+	pipeline := goal.Pipelines.For(nodeState)
+	for i, kind := range pipeline {
+		if !kindDoneForNode(kind, nodeState, goal) {
+			return i
+		}
+	}
+	return len(pipeline)
+}
+
+// nextKindForNode returns the kind of action the node needs next, or an empty
+// string if it needs none. Failed nodes never need any; they're replaced
+// instead.
+func nextKindForNode(nodeState NodeState, goal Goal) ActionKind {
+	if nodeState.failed() {
+		return ""
+	}
+	pipeline := goal.Pipelines.For(nodeState)
+	step := stepNumberForNode(nodeState, goal)
+	if step == len(pipeline) {
+		return ""
+	}
+	return pipeline[step]
+}
+
+// kindDoneForNode decides whether a node is past the given step of its
+// pipeline.
+func kindDoneForNode(kind ActionKind, nodeState NodeState, goal Goal) bool {
+	upToDate := nodeState.upToDate(goal)
+	switch kind {
+	case KindDrainNodeFromPool:
+		return upToDate || !nodeState.inPool()
+	case KindStopApp:
+		return upToDate || !nodeState.AppRunning
+	case KindUpdateSoftwareRevision:
+		return nodeState.SoftwareRevision == goal.TargetRevision
+	case KindUpdateConfig:
+		return nodeState.configUpToDate(goal.Config)
+	case KindPatchOS:
+		return nodeState.OSPatchLevel >= goal.OSPatchLevel
+	case KindRebootNode:
+		return !nodeState.NeedsReboot
+	case KindStartApp:
+		return nodeState.AppRunning
+	case KindHealthCheck:
+		return nodeState.Healthy || nodeState.inPool()
+	case KindCatchUpReplication:
+		return nodeState.ReplicationCaughtUp || nodeState.inPool()
+	case KindWarmCache:
+		return nodeState.CacheWarmed
+	case KindAddNodeToPool:
+		return nodeState.PoolWeight >= FullWeight
+	}
+	return true
+}
+
+// kindNeededForNode decides whether a node will certainly have to take an
+// action of the given kind before it reaches the target revision, OS patch
+// level and configuration, and rejoins the pool.
+func kindNeededForNode(kind ActionKind, nodeState NodeState, goal Goal) bool {
+	switch kind {
+	case KindUpdateConfig:
+		return !nodeState.configUpToDate(goal.Config)
+	case KindPatchOS:
+		return nodeState.OSPatchLevel < goal.OSPatchLevel
+	case KindRebootNode:
+		return !nodeState.osUpToDate(goal.OSPatchLevel)
+	}
+	if !nodeState.upToDate(goal) {
+		switch kind {
+		case KindDrainNodeFromPool:
+			// note that this is independent from stopping the app; we might be
+			// given a node which is stopped yet somehow (?!) still in the pool
+			return nodeState.inPool()
+		case KindStopApp:
+			return nodeState.AppRunning
+		case KindUpdateSoftwareRevision:
+			return nodeState.SoftwareRevision != goal.TargetRevision
+		}
+		return true
+	}
+
+	switch kind {
+	case KindStartApp:
+		return !nodeState.AppRunning
+	case KindHealthCheck:
+		return !nodeState.inPool() && (!nodeState.AppRunning || !nodeState.Healthy)
+	case KindCatchUpReplication:
+		return !nodeState.inPool() && (!nodeState.AppRunning || !nodeState.ReplicationCaughtUp)
+	case KindWarmCache:
+		return !nodeState.CacheWarmed
+	case KindAddNodeToPool:
+		return !nodeState.inPool()
+	}
+	return false
+}
+
+// BlockedNodes returns the names of nodes which can't progress towards the
+// target revision without intervention, because their app failed its
+// health check.
+func BlockedNodes(state State, goal Goal) []string {
+	var blocked []string
+	for _, nodeState := range state.Nodes {
+		if nextKindForNode(nodeState, goal) == KindHealthCheck && nodeState.HealthCheckFailed {
+			blocked = append(blocked, nodeState.Name)
+		}
+	}
+	return blocked
+}
@@ -0,0 +1,197 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestPipelines_Validate(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		pipelines Pipelines
+		expectErr bool
+	}{
+		"nil": {},
+		"defaults": {
+			pipelines: DefaultPipelines(),
+		},
+		"minimal": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindStartApp, KindAddNodeToPool},
+			},
+		},
+		"OS steps listed": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindPatchOS, KindRebootNode, KindStartApp, KindAddNodeToPool},
+			},
+		},
+		"config step listed": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindUpdateConfig, KindStartApp, KindAddNodeToPool},
+			},
+		},
+		"config step after patching": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindPatchOS, KindUpdateConfig, KindStartApp, KindAddNodeToPool},
+			},
+			expectErr: true,
+		},
+		"reboot after start": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindStartApp, KindRebootNode, KindAddNodeToPool},
+			},
+			expectErr: true,
+		},
+		"update before stop": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindUpdateSoftwareRevision, KindStopApp, KindStartApp, KindAddNodeToPool},
+			},
+			expectErr: true,
+		},
+		"never re-added": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindStartApp, KindWarmCache},
+			},
+			expectErr: true,
+		},
+		"unknown kind": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindStartApp, "pray", KindAddNodeToPool},
+			},
+			expectErr: true,
+		},
+		"repeated kind": {
+			pipelines: Pipelines{
+				"batch": {KindDrainNodeFromPool, KindStopApp, KindUpdateSoftwareRevision, KindStartApp, KindHealthCheck, KindHealthCheck, KindAddNodeToPool},
+			},
+			expectErr: true,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			err := tc.pipelines.Validate()
+			if tc.expectErr && err == nil {
+				t.Error("expected an error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func Test_stepNumberForNode_roles(t *testing.T) {
+	t.Parallel()
+
+	nodeState := NodeState{
+		Name:             "db1-1",
+		Cluster:          1,
+		SoftwareRevision: 2,
+		AppRunning:       true,
+		PoolWeight:       0,
+		Healthy:          true,
+	}
+
+	testCases := map[string]struct {
+		role     string
+		expected ActionKind
+	}{
+		"default role warms cache": {
+			role:     "",
+			expected: KindWarmCache,
+		},
+		"cache role goes straight back in the pool": {
+			role:     "cache",
+			expected: KindAddNodeToPool,
+		},
+		"stateful role catches up replication": {
+			role:     "stateful",
+			expected: KindCatchUpReplication,
+		},
+		"unknown role uses default pipeline": {
+			role:     "mystery",
+			expected: KindWarmCache,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			ns := nodeState
+			ns.Role = tc.role
+			actual := nextKindForNode(ns, Goal{TargetRevision: 2})
+			if actual != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_roles() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "cache1-1",
+			Cluster:          1,
+			Role:             "cache",
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "db1-1",
+			Cluster:          1,
+			Role:             "stateful",
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{}
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for _, action := range plan {
+		fmt.Println(action)
+	}
+	// Output:
+	// Drain node from pool: app1-1
+	// Drain node from pool: cache1-1
+	// Drain node from pool: db1-1
+	// Stop app: app1-1
+	// Stop app: cache1-1
+	// Stop app: db1-1
+	// Update software: app1-1
+	// Update software: cache1-1
+	// Update software: db1-1
+	// Start app: app1-1
+	// Start app: cache1-1
+	// Start app: db1-1
+	// Health check: app1-1
+	// Health check: cache1-1
+	// Health check: db1-1
+	// Warm cache: app1-1
+	// Add node to pool: app1-1
+	// Add node to pool: cache1-1
+	// Catch up replication: db1-1
+	// Add node to pool: db1-1
+}
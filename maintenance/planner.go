@@ -5,54 +5,404 @@ import (
 	"gopkg.in/yaml.v2"
 	"log"
 	"math"
-	"sort"
 	"strings"
 	"time"
 
 	"github.com/sayotte/plannerdemo/planner"
 )
 
+// Algorithm selects the search used to find a plan.
+type Algorithm string
+
+const (
+	// AlgorithmAStar is guided by an estimate of the cost still to come,
+	// and so usually expands far fewer states.
+	AlgorithmAStar Algorithm = "astar"
+	// AlgorithmDijkstra searches uniformly outwards from the starting state.
+	AlgorithmDijkstra Algorithm = "dijkstra"
+)
+
 type Planner struct {
+	// Constraints are consulted in addition to the built-in safety rules
+	// before any action is accepted into a plan.
+	Constraints []Constraint
+	// Durations is used by PlanScheduleForTargetRevision to decide how long
+	// each action will take, and in place of Costs to choose the plan.
+	Durations Durations
+	// Costs decides what makes one plan better than another; the zero value
+	// minimises the number of actions.
+	Costs CostModel
+	// GroupBy names the label by which nodes are grouped for the built-in
+	// rules: only one group may be down at once, and all nodes in a group
+	// progress through maintenance steps together. Defaults to ClusterLabel.
+	GroupBy string
+	// LooseStepSync only keeps nodes which are already under maintenance in
+	// step with the rest of their group. Without it, constraints which limit
+	// how many nodes in a group may be down at once can leave the planner
+	// unable to progress any of them.
+	LooseStepSync bool
+	// Pipelines override or extend DefaultPipelines, deciding which actions
+	// nodes of each role go through.
+	Pipelines Pipelines
+	// Dependencies between groups of nodes decide which groups must be
+	// updated before others.
+	Dependencies Dependencies
+	// Compatibility lists revisions which mustn't serve traffic together.
+	Compatibility CompatibilityMatrix
+	// SchemaMigration, if set, makes the rollout expand the schema before
+	// any node serves the target revision, and contract it once no node
+	// runs an older one.
+	SchemaMigration bool
+	// Canary, if set, upgrades the nodes it picks first, then pauses for
+	// them to be verified before any other node is taken down.
+	Canary *CanaryPolicy
+	// SafetyFactor multiplies the State's Demand to give the capacity which
+	// must stay in the pool; it also decides how many nodes may be drained
+	// at once. Defaults to 1.
+	SafetyFactor float64
+	// Scope, if set, limits the rollout to the nodes it selects; others are
+	// left as they are.
+	Scope *NodeSelector
+	// Frozen nodes are never touched, though they still count towards the
+	// fleet's availability: in the pool, or out of it.
+	Frozen NodeSelector
+	// OSPatchLevel is the operating system patch level every node must
+	// reach, alongside the target revision. Nodes are patched and rebooted,
+	// along with any others which need a reboot, in the same drain cycle as
+	// their software update; nodes already at the target revision are only
+	// taken out of the pool if their OS needs attention.
+	OSPatchLevel int
+	// Config is the configuration change every node must take. Changes
+	// which need a restart are applied in the same drain cycle as any other
+	// maintenance; hot-reloadable ones are reloaded in place, without taking
+	// nodes out of the pool, unless a node is going through that cycle
+	// anyway.
+	Config ConfigChange
+	// RampStep, if set, has nodes rejoin the load-balancer pool at that
+	// weight, as a percentage of FullWeight, and then raises their weight
+	// by as much again at a time until they reach it. Nodes otherwise
+	// rejoin at FullWeight.
+	RampStep int
+	// Drain decides how long nodes drained from the pool wait for their
+	// connections to close, and whether their app may then be stopped
+	// regardless.
+	Drain DrainPolicy
+	// Surge, if set, lets the planner add nodes at the target revision before
+	// draining old ones, and decommission the extras afterwards.
+	Surge *SurgePolicy
+	// ClusterFailover lets the planner fail a whole cluster's traffic over to
+	// the rest of the fleet at a global load balancer, and restore it once
+	// the cluster's nodes are ready, instead of draining and re-adding them
+	// one at a time, wherever that costs less. The rest of the pool must
+	// have the capacity to serve the cluster's demand meanwhile.
+	ClusterFailover bool
+	// Order decides which nodes within a group are taken first, wherever the
+	// planner has a choice between equally cheap plans; by default, nodes
+	// are taken by descending Priority, then by name.
+	Order NodeOrder
+	// Calendar, if set, places schedules in time, keeping them within
+	// maintenance windows and forecast demand.
+	Calendar *Calendar
+	// Algorithm defaults to AlgorithmAStar.
+	Algorithm Algorithm
+	// Topology, if set, replaces the one-group-down rule with limits at each
+	// level of a region/cluster/node hierarchy, and rolls out regions in the
+	// order it specifies. GroupBy is ignored; nodes progress in step with the
+	// rest of their cluster.
+	Topology *TopologyPolicy
+
 	expansions int
+	rejections map[string]int
+}
+
+// Expansions returns how many states have been expanded while generating
+// plans.
+func (p *Planner) Expansions() int {
+	return p.expansions
+}
+
+// Rejections returns, by constraint name, how many candidate actions were
+// discarded for violating that constraint while generating plans.
+func (p *Planner) Rejections() map[string]int {
+	return p.rejections
+}
+
+// Explain returns every constraint violated by taking the given action from
+// the given state, when planning towards targetSoftwareRevision.
+func (p *Planner) Explain(state State, action MaintenanceAction, targetSoftwareRevision int) []ConstraintViolation {
+	return checkConstraints(p.constraintsForTargetRevision(targetSoftwareRevision), state, action)
+}
+
+func (p *Planner) constraintsForTargetRevision(targetSoftwareRevision int) []Constraint {
+	goal := p.goal(targetSoftwareRevision)
+	var constraints []Constraint
+	if p.Topology != nil {
+		constraints = p.Topology.constraints(goal)
+		constraints = append(constraints, &GroupStepSyncConstraint{
+			Goal:         goal,
+			Label:        ClusterLabel,
+			Dependencies: p.Dependencies,
+			Canary:       p.Canary,
+			SafetyFactor: p.SafetyFactor,
+			Filter:       p.filter(),
+			InFlightOnly: p.LooseStepSync || p.Topology.MaxNodesDownPerCluster > 0,
+		})
+	} else {
+		constraints = []Constraint{
+			&OneGroupDownConstraint{
+				Goal:         goal,
+				Label:        p.groupBy(),
+				Dependencies: p.Dependencies,
+				Canary:       p.Canary,
+				SafetyFactor: p.SafetyFactor,
+				Filter:       p.filter(),
+			},
+			&GroupStepSyncConstraint{
+				Goal:         goal,
+				Label:        p.groupBy(),
+				Dependencies: p.Dependencies,
+				Canary:       p.Canary,
+				SafetyFactor: p.SafetyFactor,
+				Filter:       p.filter(),
+				InFlightOnly: p.LooseStepSync,
+			},
+		}
+	}
+	constraints = append(constraints, &CapacityConstraint{Goal: goal, SafetyFactor: p.SafetyFactor, Filter: p.filter()})
+	if len(p.Compatibility) > 0 {
+		constraints = append(constraints, &CompatibilityConstraint{Matrix: p.Compatibility})
+	}
+	if p.SchemaMigration {
+		constraints = append(constraints, &SchemaExpandedConstraint{TargetRevision: targetSoftwareRevision})
+	}
+	if p.Canary != nil {
+		constraints = append(constraints, &CanaryConstraint{Goal: goal, Canary: p.Canary})
+	}
+	return append(constraints, p.Constraints...)
+}
+
+func (p *Planner) filter() NodeFilter {
+	return NodeFilter{Scope: p.Scope, Frozen: p.Frozen}
+}
+
+func (p *Planner) groupBy() string {
+	if p.GroupBy == "" {
+		return ClusterLabel
+	}
+	return p.GroupBy
 }
 
 func (p *Planner) PlanActionsForTargetRevision(startingState State, targetSoftwareRevision int) []MaintenanceAction {
+	return p.planActions(startingState, targetSoftwareRevision, p.constraintsForTargetRevision(targetSoftwareRevision))
+}
+
+func (p *Planner) planActions(startingState State, targetSoftwareRevision int, constraints []Constraint) []MaintenanceAction {
+	err := p.Costs.Validate()
+	if err != nil {
+		log.Printf("Refusing to plan with invalid cost model: %s\n", err)
+		return nil
+	}
+	err = p.Pipelines.Validate()
+	if err != nil {
+		log.Printf("Refusing to plan with invalid pipelines: %s\n", err)
+		return nil
+	}
+	err = p.Compatibility.Validate()
+	if err != nil {
+		log.Printf("Refusing to plan with invalid compatibility matrix: %s\n", err)
+		return nil
+	}
+	err = p.Dependencies.Validate()
+	if err != nil {
+		log.Printf("Refusing to plan with invalid dependencies: %s\n", err)
+		return nil
+	}
+	err = p.Surge.Validate()
+	if err != nil {
+		log.Printf("Refusing to plan with invalid surge policy: %s\n", err)
+		return nil
+	}
+	err = validateRampStep(p.RampStep)
+	if err != nil {
+		log.Printf("Refusing to plan with invalid weight ramp: %s\n", err)
+		return nil
+	}
+	err = p.Drain.Validate()
+	if err != nil {
+		log.Printf("Refusing to plan with invalid drain policy: %s\n", err)
+		return nil
+	}
+	goal := p.goal(targetSoftwareRevision)
+	filter := p.filter()
+	var blocked []string
+	for _, name := range BlockedNodes(startingState, goal) {
+		if filter.Allows(startingState.Nodes[startingState.indexOfNode(name)]) {
+			blocked = append(blocked, name)
+		}
+	}
+	if len(blocked) > 0 {
+		log.Printf("Refusing to plan; nodes blocked by failed health checks: %s\n", strings.Join(blocked, ", "))
+		return nil
+	}
+	var timedOut []string
+	for _, name := range TimedOutDrains(startingState, p.Drain.ForceStop) {
+		if filter.Allows(startingState.Nodes[startingState.indexOfNode(name)]) {
+			timedOut = append(timedOut, name)
+		}
+	}
+	if len(timedOut) > 0 {
+		log.Printf("Refusing to plan; connections to nodes didn't drain before the timeout: %s\n", strings.Join(timedOut, ", "))
+		return nil
+	}
+	// nodes added by a surge could make room to drain the rest
+	undrainable := undrainableNodes(startingState, goal, p.SafetyFactor, filter)
+	if len(undrainable) > 0 && (p.Surge == nil || p.Surge.MaxSurge == 0) {
+		log.Printf("Refusing to plan; not enough capacity to drain nodes: %s\n", strings.Join(undrainable, ", "))
+		return nil
+	}
+	if p.Canary != nil && len(p.Canary.canaries(startingState)) == 0 {
+		log.Printf("Refusing to plan; the canary policy matches no nodes\n")
+		return nil
+	}
+	if p.Canary != nil {
+		untouchable := filter.disallowed(startingState, p.Canary.canaries(startingState))
+		if len(untouchable) > 0 {
+			log.Printf("Refusing to plan; canaries are out of scope or frozen: %s\n", strings.Join(untouchable, ", "))
+			return nil
+		}
+	}
+	unreachable := p.Dependencies.outOfReach(startingState, filter, targetSoftwareRevision)
+	if len(unreachable) > 0 {
+		log.Printf("Refusing to plan; nodes others depend on are out of scope or frozen: %s\n", strings.Join(unreachable, ", "))
+		return nil
+	}
+	if p.SchemaMigration {
+		var leftBehind []string
+		for _, nodeState := range startingState.Nodes {
+			if nodeState.SoftwareRevision < targetSoftwareRevision && !filter.Allows(nodeState) {
+				leftBehind = append(leftBehind, nodeState.Name)
+			}
+		}
+		if len(leftBehind) > 0 {
+			log.Printf("Refusing to plan; the schema can't be contracted while nodes out of scope or frozen run older revisions: %s\n", strings.Join(leftBehind, ", "))
+			return nil
+		}
+		serving := servingBeforeExpansion(startingState, targetSoftwareRevision)
+		if len(serving) > 0 {
+			log.Printf("Refusing to plan; nodes already serving revision %d before the schema was expanded: %s\n", targetSoftwareRevision, strings.Join(serving, ", "))
+			return nil
+		}
+	}
+	desired := fleetSizeOf(startingState)
 	coster := func(src, dst interface{}) float64 {
-		return 1.0
+		return p.Costs.Cost(dst.(MaintenanceAction))
 	}
 
 	isGoaler := func(n interface{}) bool {
 		action := n.(MaintenanceAction)
 		state := action.FinalState()
-		for _, nodeState := range state {
-			if nodeState.SoftwareRevision != targetSoftwareRevision {
+		for _, nodeState := range state.Nodes {
+			if !filter.Allows(nodeState) {
+				continue
+			}
+			if nodeState.failed() {
+				if !nodeState.retired() {
+					return false
+				}
+				continue
+			}
+			if !nodeState.upToDate(goal) || !nodeState.configUpToDate(p.Config) {
 				return false
 			}
-			if !nodeState.InLoadbalancerPool {
+			if nodeState.PoolWeight < FullWeight {
 				return false
 			}
 		}
+		if p.SchemaMigration && !schemaDone(state, targetSoftwareRevision) {
+			return false
+		}
+		if p.Canary != nil && !state.Canary.verified(goal) {
+			return false
+		}
+		if p.Surge != nil && desired.excess(fleetSizeOf(state)) > 0 {
+			return false
+		}
+		if len(state.Traffic.FailedOver) > 0 {
+			return false
+		}
 		return true
 	}
 
 	estimator := func(n interface{}) float64 {
 		action := n.(MaintenanceAction)
-		return estimateAction(action, targetSoftwareRevision)
+		var cost float64
+		if p.Surge != nil && p.Surge.MaxSurge > 0 {
+			cost = estimateSurge(action.FinalState(), goal, desired, p.RampStep, p.Costs, filter)
+		} else {
+			cost = estimateAction(action, goal, p.Costs, filter)
+			cost += estimateRamp(action.FinalState(), goal, p.RampStep, p.Costs, filter)
+		}
+		if p.SchemaMigration {
+			cost += estimateSchema(action.FinalState(), targetSoftwareRevision, p.Costs)
+		}
+		if p.Canary != nil && !action.FinalState().Canary.verified(goal) {
+			cost += p.Costs.costForKind(KindPauseForVerification)
+		}
+		cost += estimateFailover(action.FinalState(), goal, p.RampStep, p.Costs, filter, p.ClusterFailover)
+		return cost
 		//startingState := n.(MaintenanceAction).FinalState()
 		//var cost float64
-		//for _,node := range startingState {
+		//for _,node := range startingState.Nodes {
 		//	cost += baseEstimateForNode(node, startingState, targetSoftwareRevision)
 		//}
 		//return cost
 	}
 
 	availableActionPrototypes := []MaintenanceAction{
-		&DrainNodeFromPoolAction{TargetRevision: targetSoftwareRevision},
-		&StopAppAction{TargetRevision: targetSoftwareRevision},
-		&UpdateSoftwareRevisionAction{TargetRevision: targetSoftwareRevision},
-		&StartAppAction{TargetRevision: targetSoftwareRevision},
-		&WarmCacheAction{TargetRevision: targetSoftwareRevision},
-		&AddNodeToPoolAction{TargetRevision: targetSoftwareRevision},
+		&DrainNodeFromPoolAction{Goal: goal, Dependencies: p.Dependencies, AwaitConnections: p.Drain.Timeout > 0, Filter: filter},
+		&WaitForDrainAction{Goal: goal, Timeout: p.Drain.Timeout, Filter: filter},
+		&StopAppAction{Goal: goal, ForceStop: p.Drain.ForceStop, Filter: filter},
+		&UpdateSoftwareRevisionAction{Goal: goal, Dependencies: p.Dependencies, Filter: filter},
+		&StartAppAction{Goal: goal, Filter: filter},
+		&HealthCheckAction{Goal: goal, Filter: filter},
+		&CatchUpReplicationAction{Goal: goal, Filter: filter},
+		&WarmCacheAction{Goal: goal, Filter: filter},
+		&AddNodeToPoolAction{Goal: goal, RampStep: p.RampStep, Filter: filter},
+		&RampWeightAction{Goal: goal, RampStep: p.RampStep, Filter: filter},
+		&PatchOSAction{Goal: goal, Filter: filter},
+		&RebootNodeAction{Goal: goal, Filter: filter},
+		&UpdateConfigAction{Goal: goal, Filter: filter},
+		&ReloadConfigAction{Goal: goal, Filter: filter},
+		&RemoveFromPoolAction{Filter: filter},
+		&MarkForReplacementAction{Filter: filter},
+		&ProvisionReplacementAction{Goal: goal, Filter: filter},
+		// clusters may already have been failed over, even if we won't
+		&RestoreClusterTrafficAction{Goal: goal, Filter: filter},
+	}
+	if p.SchemaMigration {
+		availableActionPrototypes = append(availableActionPrototypes,
+			&ExpandSchemaAction{TargetRevision: targetSoftwareRevision},
+			&ContractSchemaAction{TargetRevision: targetSoftwareRevision},
+		)
+	}
+	if p.Canary != nil {
+		availableActionPrototypes = append(availableActionPrototypes,
+			&PauseForVerificationAction{Goal: goal, Canary: p.Canary},
+		)
+	}
+	if p.Surge != nil {
+		availableActionPrototypes = append(availableActionPrototypes,
+			&ProvisionNodeAction{Goal: goal, MaxSurge: p.Surge.MaxSurge, Dependencies: p.Dependencies, Canary: p.Canary, Filter: filter, desired: desired},
+			&DecommissionNodeAction{Filter: filter, desired: desired},
+		)
+	}
+	if p.ClusterFailover {
+		availableActionPrototypes = append(availableActionPrototypes,
+			&FailoverClusterTrafficAction{Goal: goal, Dependencies: p.Dependencies, Canary: p.Canary, Filter: filter},
+		)
+	}
+	if p.rejections == nil {
+		p.rejections = make(map[string]int)
 	}
 	neighborGen := func(n interface{}) []interface{} {
 		p.expansions += 1
@@ -60,7 +410,16 @@ func (p *Planner) PlanActionsForTargetRevision(startingState State, targetSoftwa
 		startingState := n.(MaintenanceAction).FinalState()
 		var possibleActions []MaintenanceAction
 		for _, actionProto := range availableActionPrototypes {
-			possibleActions = append(possibleActions, actionProto.CloneForValidTargets(startingState)...)
+			for _, action := range actionProto.CloneForValidTargets(startingState) {
+				violations := checkConstraints(constraints, startingState, action)
+				for _, violation := range violations {
+					p.rejections[violation.Constraint] += 1
+				}
+				if len(violations) > 0 {
+					continue
+				}
+				possibleActions = append(possibleActions, action)
+			}
 		}
 		// have to return []interface{}
 		ret := make([]interface{}, 0, len(possibleActions))
@@ -77,20 +436,48 @@ func (p *Planner) PlanActionsForTargetRevision(startingState State, targetSoftwa
 		return ret
 	}
 
+	ranks := p.Order.ranks(startingState)
+	options := planner.Options{
+		// every action is a distinct search node, so without this each order
+		// of the same actions would be expanded separately; with costs that
+		// vary by node there's no telling which order the search would
+		// settle on first
+		Keyer: func(n interface{}) interface{} {
+			return n.(MaintenanceAction).FinalState().key()
+		},
+		Ranker: func(src, dst interface{}) int {
+			return ranks.rankStep(src.(MaintenanceAction), dst.(MaintenanceAction))
+		},
+	}
+	start := &DoNothingAction{finalState: startingState}
+
 	startTime := time.Now()
-	//cameFrom, costSoFar, finalAction := DijkstraFindPath(
-	cameFrom, costSoFar, finalAction := planner.AStarFindPath(
-		&DoNothingAction{finalState: startingState},
-		coster,
-		estimator,
-		isGoaler,
-		neighborGen,
-	)
+	var cameFrom map[interface{}]interface{}
+	var costSoFar map[interface{}]float64
+	var finalAction interface{}
+	switch p.Algorithm {
+	case AlgorithmAStar, "":
+		cameFrom, costSoFar, finalAction = planner.AStarFindPathWithOptions(start, coster, estimator, isGoaler, neighborGen, options)
+	case AlgorithmDijkstra:
+		cameFrom, costSoFar, finalAction = planner.DijkstraFindPathWithOptions(start, coster, isGoaler, neighborGen, options)
+	default:
+		log.Printf("Refusing to plan with unknown algorithm %q\n", p.Algorithm)
+		return nil
+	}
 	runTime := time.Since(startTime)
 	log.Printf("Plan generated in %s; total expansions %d; total cost %f\n", runTime, p.expansions, costSoFar[finalAction])
 
 	// if it didn't find any workable path, exit early
 	if finalAction == nil {
+		var down []string
+		for _, nodeState := range startingState.Nodes {
+			if !nodeState.inPool() && !filter.Allows(nodeState) {
+				down = append(down, nodeState.Name)
+			}
+		}
+		if len(down) > 0 {
+			log.Printf("No plan found; nodes out of scope or frozen still count as down: %s\n", strings.Join(down, ", "))
+		}
 		return nil
 	}
 	// rebuild the plan, working backwards from the final action
@@ -112,7 +499,15 @@ func (p *Planner) PlanActionsForTargetRevision(startingState State, targetSoftwa
 	return plan
 }
 
-type State []NodeState
+// State describes the whole fleet: each of its nodes, plus anything tracked
+// fleet-wide rather than per node.
+type State struct {
+	Nodes   []NodeState
+	Schema  SchemaState
+	Canary  CanaryState
+	Demand  Demand
+	Traffic TrafficState
+}
 
 func (s State) String() string {
 	outB, err := yaml.Marshal(s)
@@ -122,19 +517,152 @@ func (s State) String() string {
 	return string(outB)
 }
 
+// stateYAML is how a State with fleet-wide state is written out; States
+// without any are written as a plain list of nodes, as they always were.
+type stateYAML struct {
+	Nodes   []NodeState  `yaml:"nodes"`
+	Schema  SchemaState  `yaml:"schema,omitempty"`
+	Canary  CanaryState  `yaml:"canary,omitempty"`
+	Demand  Demand       `yaml:"demand,omitempty"`
+	Traffic TrafficState `yaml:"traffic,omitempty"`
+}
+
+func (s State) MarshalYAML() (interface{}, error) {
+	if s.Schema == (SchemaState{}) && s.Canary == (CanaryState{}) && s.Demand.isZero() && len(s.Traffic.FailedOver) == 0 {
+		return s.Nodes, nil
+	}
+	return stateYAML(s), nil
+}
+
+func (s *State) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var nodes []NodeState
+	if unmarshal(&nodes) == nil {
+		*s = State{Nodes: nodes}
+		return nil
+	}
+	var sy stateYAML
+	err := unmarshal(&sy)
+	if err != nil {
+		return err
+	}
+	*s = State(sy)
+	return nil
+}
+
+// key identifies a State independently of how it was reached, so that the
+// planner needn't explore every ordering of the same set of actions.
+func (s State) key() string {
+	return fmt.Sprintf("%+v", s)
+}
+
+func (s State) indexOfNode(name string) int {
+	for i, nodeState := range s.Nodes {
+		if nodeState.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// withNode returns a copy of the State with its i'th node replaced.
+func (s State) withNode(i int, nodeState NodeState) State {
+	nodes := make([]NodeState, len(s.Nodes))
+	copy(nodes, s.Nodes)
+	nodes[i] = nodeState
+	s.Nodes = nodes
+	return s
+}
+
 type NodeState struct {
-	Name               string
-	Cluster            int
-	SoftwareRevision   int
-	AppRunning         bool
-	InLoadbalancerPool bool
-	CacheWarmed        bool
+	Name             string
+	Cluster          int
+	SoftwareRevision int
+	AppRunning       bool
+	// PoolWeight is the node's weight in the load-balancer pool, as a
+	// percentage of FullWeight; a node with no weight is out of the pool.
+	PoolWeight  int `yaml:",omitempty"`
+	CacheWarmed bool
+	// Healthy is set once the app has passed a health check since it was
+	// last started.
+	Healthy bool `yaml:",omitempty"`
+	// HealthCheckFailed is set by an executor when the app fails its health
+	// check; the planner won't retry it, and reports the node as blocked.
+	HealthCheckFailed bool `yaml:",omitempty"`
+	// Role selects the Pipeline of actions the node goes through; empty
+	// means DefaultRole.
+	Role string `yaml:",omitempty"`
+	// ReplicationCaughtUp is set once a stateful node's replicas have caught
+	// up since its app was last started.
+	ReplicationCaughtUp bool `yaml:",omitempty"`
+	// Capacity is how many requests per second the node can serve while
+	// it's in the pool.
+	Capacity float64 `yaml:",omitempty"`
+	// Health is the node's observed health; failed nodes are replaced
+	// rather than upgraded.
+	Health HealthStatus `yaml:",omitempty"`
+	// MarkedForReplacement is set on a failed node once it's been decided to
+	// replace it.
+	MarkedForReplacement bool `yaml:",omitempty"`
+	// ReplacedBy names the node provisioned to replace a failed one.
+	ReplacedBy string `yaml:",omitempty"`
+	// Replaces names the failed node this one was provisioned to replace.
+	Replaces string `yaml:",omitempty"`
+	// Provisioned is set on nodes the rollout has added to the fleet.
+	Provisioned bool `yaml:",omitempty"`
+	// OSPatchLevel is the patch level of the node's operating system, as
+	// installed; it's only running once the node has been rebooted.
+	OSPatchLevel int `yaml:",omitempty"`
+	// NeedsReboot is set while the node must be rebooted, e.g. to run a
+	// newly patched kernel.
+	NeedsReboot bool `yaml:",omitempty"`
+	// ConfigVersion is the version of the configuration the node's app has
+	// loaded.
+	ConfigVersion int `yaml:",omitempty"`
+	// ActiveConnections is how many connections to the node's app are open,
+	// as last reported by an executor; nodes drained from the pool wait for
+	// them to close before their app is stopped.
+	ActiveConnections int `yaml:",omitempty"`
+	// DrainTimedOut is set by an executor when connections to the node were
+	// still open once the wait for them timed out.
+	DrainTimedOut bool `yaml:",omitempty"`
+	// AwaitingConnections is set on a node drained from the pool under a
+	// DrainPolicy with a Timeout, until it has waited for its connections to
+	// close; whatever ActiveConnections said when the plan was made, more
+	// may have been opened since.
+	AwaitingConnections bool `yaml:",omitempty"`
+	// Priority decides which nodes the planner takes first, wherever it has
+	// a choice; higher priorities go first.
+	Priority int `yaml:",omitempty"`
+	// Labels hold arbitrary topology information, e.g. zone or rack.
+	Labels map[string]string `yaml:",omitempty"`
 }
 
+// ActionKind identifies a type of MaintenanceAction, independent of the node
+// it targets.
+type ActionKind string
+
+const (
+	KindDrainNodeFromPool      ActionKind = "drainnodefrompool"
+	KindStopApp                ActionKind = "stopapp"
+	KindUpdateSoftwareRevision ActionKind = "updatesoftwarerevision"
+	KindStartApp               ActionKind = "startapp"
+	KindHealthCheck            ActionKind = "healthcheck"
+	KindCatchUpReplication     ActionKind = "catchupreplication"
+	KindWarmCache              ActionKind = "warmcache"
+	KindAddNodeToPool          ActionKind = "addnodetopool"
+	KindExpandSchema           ActionKind = "expandschema"
+	KindContractSchema         ActionKind = "contractschema"
+	KindPauseForVerification   ActionKind = "pauseforverification"
+)
+
 type MaintenanceAction interface {
 	fmt.Stringer
 	CloneForValidTargets(startingState State) []MaintenanceAction
 	FinalState() State
+	// NodeName returns the name of the node this action operates on, or an
+	// empty string if it doesn't operate on a single node.
+	NodeName() string
+	Kind() ActionKind
 }
 
 // used for debugging up in PlanActionsForTargetRevision
@@ -164,10 +692,23 @@ func (dna DoNothingAction) FinalState() State {
 	return dna.finalState
 }
 
+func (dna DoNothingAction) NodeName() string {
+	return ""
+}
+
+func (dna DoNothingAction) Kind() ActionKind {
+	return ""
+}
+
 type DrainNodeFromPoolAction struct {
-	TargetRevision int
-	finalState     State
-	nodeName       string
+	Goal
+	Filter       NodeFilter
+	Dependencies Dependencies
+	// AwaitConnections marks drained nodes as AwaitingConnections, so that
+	// they wait for their connections to close before they're stopped.
+	AwaitConnections bool
+	finalState       State
+	nodeName         string
 }
 
 func (dnfpa *DrainNodeFromPoolAction) String() string {
@@ -177,26 +718,30 @@ func (dnfpa *DrainNodeFromPoolAction) String() string {
 func (dnfpa *DrainNodeFromPoolAction) CloneForValidTargets(startingState State) []MaintenanceAction {
 	var out []MaintenanceAction
 
-	downableCluster := getDownableCluster(startingState, dnfpa.TargetRevision)
-
-	// clone for all nodes in the LB pool and in the "downable" cluster
-	for i, nodeState := range startingState {
-		nodeStep := stepNumberForNode(nodeState, dnfpa.TargetRevision)
-		if nodeStep != 0 {
+	// clone for all nodes in the LB pool; OneGroupDownConstraint decides
+	// which of them may actually be taken down
+	for i, nodeState := range startingState.Nodes {
+		if !dnfpa.Filter.Allows(nodeState) {
 			continue
 		}
-		if nodeState.Cluster != downableCluster {
+		if nextKindForNode(nodeState, dnfpa.Goal) != KindDrainNodeFromPool {
+			continue
+		}
+		if dnfpa.Dependencies.unmetFor(startingState, nodeState, dnfpa.TargetRevision) != nil {
 			continue
 		}
 		newNodeState := nodeState
-		newNodeState.InLoadbalancerPool = false
+		newNodeState.PoolWeight = 0
+		newNodeState.AwaitingConnections = dnfpa.AwaitConnections && nodeState.AppRunning
 
-		newState := make(State, len(startingState))
-		copy(newState, startingState)
-		newState[i] = newNodeState
+		newState := startingState.withNode(i, newNodeState)
 		newAction := &DrainNodeFromPoolAction{
-			nodeName:   newNodeState.Name,
-			finalState: newState,
+			nodeName:         newNodeState.Name,
+			finalState:       newState,
+			Goal:             dnfpa.Goal,
+			Filter:           dnfpa.Filter,
+			Dependencies:     dnfpa.Dependencies,
+			AwaitConnections: dnfpa.AwaitConnections,
 		}
 		out = append(out, newAction)
 	}
@@ -207,10 +752,22 @@ func (dnfpa *DrainNodeFromPoolAction) FinalState() State {
 	return dnfpa.finalState
 }
 
+func (dnfpa *DrainNodeFromPoolAction) NodeName() string {
+	return dnfpa.nodeName
+}
+
+func (dnfpa *DrainNodeFromPoolAction) Kind() ActionKind {
+	return KindDrainNodeFromPool
+}
+
+// StopAppAction stops the app on a drained node, once connections to it
+// have closed, or once the wait for them has timed out if ForceStop is set.
 type StopAppAction struct {
-	TargetRevision int
-	nodeName       string
-	finalState     State
+	Goal
+	ForceStop  bool
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
 }
 
 func (sa *StopAppAction) String() string {
@@ -220,32 +777,32 @@ func (sa *StopAppAction) String() string {
 func (sa *StopAppAction) CloneForValidTargets(startingState State) []MaintenanceAction {
 	var out []MaintenanceAction
 
-	downableCluster := getDownableCluster(startingState, sa.TargetRevision)
-
 	// clone for all nodes not in the LB pool with running apps
-	for i, nodeState := range startingState {
-		nodeStep := stepNumberForNode(nodeState, sa.TargetRevision)
-		if nodeStep != 1 {
+	for i, nodeState := range startingState.Nodes {
+		if !sa.Filter.Allows(nodeState) {
 			continue
 		}
-		lowStep := lowestStepForCluster(startingState, nodeState.Cluster, sa.TargetRevision)
-		if lowStep < 1 {
+		if nextKindForNode(nodeState, sa.Goal) != KindStopApp {
 			continue
 		}
-		if nodeState.Cluster != downableCluster {
+		if !nodeState.mayStop(sa.ForceStop) {
 			continue
 		}
 		newNodeState := nodeState
 		newNodeState.AppRunning = false
+		newNodeState.ActiveConnections = 0
+		newNodeState.DrainTimedOut = false
 		newNodeState.CacheWarmed = false
+		newNodeState.Healthy = false
+		newNodeState.ReplicationCaughtUp = false
 
-		newState := make(State, len(startingState))
-		copy(newState, startingState)
-		newState[i] = newNodeState
+		newState := startingState.withNode(i, newNodeState)
 		newAction := &StopAppAction{
-			nodeName:       newNodeState.Name,
-			finalState:     newState,
-			TargetRevision: sa.TargetRevision,
+			nodeName:   newNodeState.Name,
+			finalState: newState,
+			Goal:       sa.Goal,
+			ForceStop:  sa.ForceStop,
+			Filter:     sa.Filter,
 		}
 		out = append(out, newAction)
 	}
@@ -256,10 +813,20 @@ func (sa *StopAppAction) FinalState() State {
 	return sa.finalState
 }
 
+func (sa *StopAppAction) NodeName() string {
+	return sa.nodeName
+}
+
+func (sa *StopAppAction) Kind() ActionKind {
+	return KindStopApp
+}
+
 type UpdateSoftwareRevisionAction struct {
-	TargetRevision int
-	finalState     State
-	nodeName       string
+	Goal
+	Filter       NodeFilter
+	Dependencies Dependencies
+	finalState   State
+	nodeName     string
 }
 
 func (usra *UpdateSoftwareRevisionAction) String() string {
@@ -269,25 +836,28 @@ func (usra *UpdateSoftwareRevisionAction) String() string {
 func (usra *UpdateSoftwareRevisionAction) CloneForValidTargets(startingState State) []MaintenanceAction {
 	var out []MaintenanceAction
 
-	// clone for all nodes without running apps, running the wrong revision
-	for i, nodeState := range startingState {
-		nodeStep := stepNumberForNode(nodeState, usra.TargetRevision)
-		if nodeStep != 2 {
+	// clone for all nodes without running apps, running the wrong revision,
+	// whose dependencies are already at the target revision
+	for i, nodeState := range startingState.Nodes {
+		if !usra.Filter.Allows(nodeState) {
 			continue
 		}
-		lowStep := lowestStepForCluster(startingState, nodeState.Cluster, usra.TargetRevision)
-		if lowStep < 2 {
+		if nextKindForNode(nodeState, usra.Goal) != KindUpdateSoftwareRevision {
+			continue
+		}
+		if usra.Dependencies.unmetFor(startingState, nodeState, usra.TargetRevision) != nil {
 			continue
 		}
 		newNodeState := nodeState
 		newNodeState.SoftwareRevision = usra.TargetRevision
 
-		newState := make(State, len(startingState))
-		copy(newState, startingState)
-		newState[i] = newNodeState
+		newState := startingState.withNode(i, newNodeState)
 		newAction := &UpdateSoftwareRevisionAction{
-			nodeName:   newNodeState.Name,
-			finalState: newState,
+			nodeName:     newNodeState.Name,
+			finalState:   newState,
+			Goal:         usra.Goal,
+			Filter:       usra.Filter,
+			Dependencies: usra.Dependencies,
 		}
 		out = append(out, newAction)
 	}
@@ -298,10 +868,19 @@ func (usra *UpdateSoftwareRevisionAction) FinalState() State {
 	return usra.finalState
 }
 
+func (usra *UpdateSoftwareRevisionAction) NodeName() string {
+	return usra.nodeName
+}
+
+func (usra *UpdateSoftwareRevisionAction) Kind() ActionKind {
+	return KindUpdateSoftwareRevision
+}
+
 type StartAppAction struct {
-	TargetRevision int
-	nodeName       string
-	finalState     State
+	Goal
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
 }
 
 func (sa *StartAppAction) String() string {
@@ -312,26 +891,26 @@ func (sa *StartAppAction) CloneForValidTargets(startingState State) []Maintenanc
 	var out []MaintenanceAction
 
 	// clone for all nodes without running apps
-	for i, nodeState := range startingState {
-		nodeStep := stepNumberForNode(nodeState, sa.TargetRevision)
-		if nodeStep != 3 {
+	for i, nodeState := range startingState.Nodes {
+		if !sa.Filter.Allows(nodeState) {
 			continue
 		}
-		lowStep := lowestStepForCluster(startingState, nodeState.Cluster, sa.TargetRevision)
-		if lowStep < 3 {
+		if nextKindForNode(nodeState, sa.Goal) != KindStartApp {
 			continue
 		}
 		newNodeState := nodeState
 		newNodeState.AppRunning = true
 		newNodeState.CacheWarmed = false
+		newNodeState.Healthy = false
+		newNodeState.HealthCheckFailed = false
+		newNodeState.ReplicationCaughtUp = false
 
-		newState := make(State, len(startingState))
-		copy(newState, startingState)
-		newState[i] = newNodeState
+		newState := startingState.withNode(i, newNodeState)
 		newAction := &StartAppAction{
-			nodeName:       newNodeState.Name,
-			finalState:     newState,
-			TargetRevision: sa.TargetRevision,
+			nodeName:   newNodeState.Name,
+			finalState: newState,
+			Goal:       sa.Goal,
+			Filter:     sa.Filter,
 		}
 		out = append(out, newAction)
 	}
@@ -342,10 +921,121 @@ func (sa *StartAppAction) FinalState() State {
 	return sa.finalState
 }
 
+func (sa *StartAppAction) NodeName() string {
+	return sa.nodeName
+}
+
+func (sa *StartAppAction) Kind() ActionKind {
+	return KindStartApp
+}
+
+type HealthCheckAction struct {
+	Goal
+	Filter     NodeFilter
+	finalState State
+	nodeName   string
+}
+
+func (hca *HealthCheckAction) String() string {
+	return fmt.Sprintf("Health check: %s", hca.nodeName)
+}
+
+func (hca *HealthCheckAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+
+	// clone for all nodes with running apps which haven't been checked yet;
+	// a node which has already failed its check is blocked, not retried
+	for i, nodeState := range startingState.Nodes {
+		if !hca.Filter.Allows(nodeState) {
+			continue
+		}
+		if nextKindForNode(nodeState, hca.Goal) != KindHealthCheck {
+			continue
+		}
+		if nodeState.HealthCheckFailed {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.Healthy = true
+
+		newState := startingState.withNode(i, newNodeState)
+		newAction := &HealthCheckAction{
+			nodeName:   newNodeState.Name,
+			finalState: newState,
+			Goal:       hca.Goal,
+			Filter:     hca.Filter,
+		}
+		out = append(out, newAction)
+	}
+	return out
+}
+
+func (hca *HealthCheckAction) FinalState() State {
+	return hca.finalState
+}
+
+func (hca *HealthCheckAction) NodeName() string {
+	return hca.nodeName
+}
+
+func (hca *HealthCheckAction) Kind() ActionKind {
+	return KindHealthCheck
+}
+
+type CatchUpReplicationAction struct {
+	Goal
+	Filter     NodeFilter
+	finalState State
+	nodeName   string
+}
+
+func (cura *CatchUpReplicationAction) String() string {
+	return fmt.Sprintf("Catch up replication: %s", cura.nodeName)
+}
+
+func (cura *CatchUpReplicationAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+
+	// clone for all nodes with healthy running apps whose replicas are behind
+	for i, nodeState := range startingState.Nodes {
+		if !cura.Filter.Allows(nodeState) {
+			continue
+		}
+		if nextKindForNode(nodeState, cura.Goal) != KindCatchUpReplication {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.ReplicationCaughtUp = true
+
+		newState := startingState.withNode(i, newNodeState)
+		newAction := &CatchUpReplicationAction{
+			nodeName:   newNodeState.Name,
+			finalState: newState,
+			Goal:       cura.Goal,
+			Filter:     cura.Filter,
+		}
+		out = append(out, newAction)
+	}
+	return out
+}
+
+func (cura *CatchUpReplicationAction) FinalState() State {
+	return cura.finalState
+}
+
+func (cura *CatchUpReplicationAction) NodeName() string {
+	return cura.nodeName
+}
+
+func (cura *CatchUpReplicationAction) Kind() ActionKind {
+	return KindCatchUpReplication
+}
+
 type WarmCacheAction struct {
-	TargetRevision int
-	finalState     State
-	nodeName       string
+	Goal
+	Filter     NodeFilter
+	finalState State
+	nodeName   string
 }
 
 func (wca *WarmCacheAction) String() string {
@@ -355,26 +1045,23 @@ func (wca *WarmCacheAction) String() string {
 func (wca *WarmCacheAction) CloneForValidTargets(startingState State) []MaintenanceAction {
 	var out []MaintenanceAction
 
-	// clone for all nodes not in the LB pool, with running apps, and with cold caches
-	for i, nodeState := range startingState {
-		nodeStep := stepNumberForNode(nodeState, wca.TargetRevision)
-		if nodeStep != 4 {
+	// clone for all nodes with healthy running apps and cold caches
+	for i, nodeState := range startingState.Nodes {
+		if !wca.Filter.Allows(nodeState) {
 			continue
 		}
-		lowStep := lowestStepForCluster(startingState, nodeState.Cluster, wca.TargetRevision)
-		if lowStep < 4 {
+		if nextKindForNode(nodeState, wca.Goal) != KindWarmCache {
 			continue
 		}
 		newNodeState := nodeState
 		newNodeState.CacheWarmed = true
 
-		newState := make(State, len(startingState))
-		copy(newState, startingState)
-		newState[i] = newNodeState
+		newState := startingState.withNode(i, newNodeState)
 		newAction := &WarmCacheAction{
-			nodeName:       newNodeState.Name,
-			finalState:     newState,
-			TargetRevision: wca.TargetRevision,
+			nodeName:   newNodeState.Name,
+			finalState: newState,
+			Goal:       wca.Goal,
+			Filter:     wca.Filter,
 		}
 		out = append(out, newAction)
 	}
@@ -385,10 +1072,22 @@ func (wca *WarmCacheAction) FinalState() State {
 	return wca.finalState
 }
 
+func (wca *WarmCacheAction) NodeName() string {
+	return wca.nodeName
+}
+
+func (wca *WarmCacheAction) Kind() ActionKind {
+	return KindWarmCache
+}
+
+// AddNodeToPoolAction returns a node to the load-balancer pool, at the first
+// step of its weight ramp if RampStep is set, and at FullWeight otherwise.
 type AddNodeToPoolAction struct {
-	TargetRevision int
-	finalState     State
-	nodeName       string
+	Goal
+	RampStep   int
+	Filter     NodeFilter
+	finalState State
+	nodeName   string
 }
 
 func (antpa *AddNodeToPoolAction) String() string {
@@ -398,27 +1097,30 @@ func (antpa *AddNodeToPoolAction) String() string {
 func (antpa *AddNodeToPoolAction) CloneForValidTargets(startingState State) []MaintenanceAction {
 	var out []MaintenanceAction
 
-	// clone for all nodes not in the LB pool with running app and cache warmed
-	for i, nodeState := range startingState {
-		nodeStep := stepNumberForNode(nodeState, antpa.TargetRevision)
-		if nodeStep != 5 {
+	// clone for all nodes not in the LB pool with healthy running app and cache warmed
+	for i, nodeState := range startingState.Nodes {
+		if !antpa.Filter.Allows(nodeState) {
+			continue
+		}
+		if nextKindForNode(nodeState, antpa.Goal) != KindAddNodeToPool {
 			continue
 		}
-		lowStep := lowestStepForCluster(startingState, nodeState.Cluster, antpa.TargetRevision)
-		if lowStep < 5 {
+		// nodes already in the pool are ramped up by RampWeightAction, and
+		// those in a failed-over cluster rejoin when its traffic is restored
+		if nodeState.inPool() || startingState.Traffic.failedOver(nodeState.Cluster) {
 			continue
 		}
 
 		newNodeState := nodeState
-		newNodeState.InLoadbalancerPool = true
+		newNodeState.PoolWeight = rampStepOrDefault(antpa.RampStep)
 
-		newState := make(State, len(startingState))
-		copy(newState, startingState)
-		newState[i] = newNodeState
+		newState := startingState.withNode(i, newNodeState)
 		newAction := &AddNodeToPoolAction{
-			finalState:     newState,
-			nodeName:       newNodeState.Name,
-			TargetRevision: antpa.TargetRevision,
+			finalState: newState,
+			nodeName:   newNodeState.Name,
+			Goal:       antpa.Goal,
+			RampStep:   antpa.RampStep,
+			Filter:     antpa.Filter,
 		}
 		out = append(out, newAction)
 	}
@@ -429,90 +1131,72 @@ func (antpa *AddNodeToPoolAction) FinalState() State {
 	return antpa.finalState
 }
 
-func baseEstimateForNode(nodeState NodeState, targetRevision int) float64 {
-	var cost float64
+func (antpa *AddNodeToPoolAction) NodeName() string {
+	return antpa.nodeName
+}
 
-	// calculate base cost on how many steps this node must absolutely complete
-	if nodeState.SoftwareRevision != targetRevision {
-		// at minimum have to upgrade the app, start it, warm the cache, and add to the pool
-		cost += 4
+func (antpa *AddNodeToPoolAction) Kind() ActionKind {
+	return KindAddNodeToPool
+}
 
-		// have to drain it from the pool before upgrading
-		// note that this is independent from stopping the app; we might be given
-		// a node which is stopped yet somehow (?!) still in the pool
-		if nodeState.InLoadbalancerPool {
-			cost += 1
-		}
+func baseEstimateForNode(nodeState NodeState, goal Goal, costs CostModel) float64 {
+	var cost float64
 
-		// have to stop the app before upgrading
-		if nodeState.AppRunning {
-			cost += 1
-		}
-	} else {
-		if !nodeState.AppRunning {
-			cost += 1
+	// calculate base cost on which steps of its pipeline this node must
+	// absolutely complete
+	for _, kind := range goal.Pipelines.For(nodeState) {
+		if !kindNeededForNode(kind, nodeState, goal) {
+			continue
 		}
-		if !nodeState.CacheWarmed {
-			cost += 1
+		// a node which needs no restart has its config hot-reloaded
+		if kind == KindUpdateConfig && nodeState.upToDate(goal) {
+			kind = configKindForNode(nodeState, goal.Config)
 		}
-		if !nodeState.InLoadbalancerPool {
-			cost += 1
+		cost += costs.costForNode(nodeState, kind)
+		// connections to the node must close before its app is stopped
+		if kind == KindStopApp && nodeState.awaitsConnections() {
+			cost += costs.costForNode(nodeState, KindWaitForDrain)
 		}
 	}
 
 	return cost
 }
 
-func estimateAction(action MaintenanceAction, targetRevision int) float64 {
+func estimateAction(action MaintenanceAction, goal Goal, costs CostModel, filter NodeFilter) float64 {
 	var maxCost float64
-	for _, nodeState := range action.FinalState() {
-		maxCost += baseEstimateForNode(nodeState, targetRevision)
+	for _, nodeState := range action.FinalState().Nodes {
+		if !filter.Allows(nodeState) {
+			continue
+		}
+		if nodeState.failed() {
+			maxCost += estimateReplacement(nodeState, costs)
+			continue
+		}
+		maxCost += baseEstimateForNode(nodeState, goal, costs)
 	}
 
 	return maxCost
 }
 
-// steps are these:
-// 0- maintenance not started; node is in LB pool
-// 1- maintenance started; node removed from LB pool
-// 2- app stopped
-// 3- software updated
-// 4- app started
-// 5- cache warmed
-// 6- maintenance complete; node added back to LB pool
-//
-// Note that we're discarding invalid states here, e.g. the app isn't running
-// but it's in the LB pool is treated as step 0/6; if treated as step 0 it'll
-// end up correctly skipping stopping the app anyway
-func stepNumberForNode(nodeState NodeState, targetRevision int) int {
-	if nodeState.SoftwareRevision != targetRevision && nodeState.InLoadbalancerPool {
-		return 0
-	}
-	if nodeState.SoftwareRevision != targetRevision && nodeState.AppRunning {
-		return 1
-	}
-	if nodeState.SoftwareRevision != targetRevision {
-		return 2
-	}
-	if !nodeState.AppRunning {
-		return 3
-	}
-	if !nodeState.CacheWarmed {
-		return 4
-	}
-	if !nodeState.InLoadbalancerPool {
-		return 5
-	}
-	return 6
-}
-
-func lowestStepForCluster(state State, clusterNum, targetRevision int) int {
+// lowestStepForGroup returns the lowest step of any node with the given role
+// whose label matches groupValue, ignoring failed nodes, and replacements
+// and surge nodes on their way into the pool, which don't take part in the
+// rollout, and nodes the gate holds back or its filter doesn't allow to be
+// touched. If inFlightOnly is set, only nodes which have started but not
+// finished maintenance are considered.
+func lowestStepForGroup(state State, label, groupValue, role string, gate startGate, inFlightOnly bool) int {
 	lowestStep := math.MaxInt64
-	for _, nodeState := range state {
-		if nodeState.Cluster != clusterNum {
+	for _, nodeState := range state.Nodes {
+		if nodeState.Label(label) != groupValue || nodeState.roleOrDefault() != role {
+			continue
+		}
+		if nodeState.failed() || nodeState.replacing() || nodeState.joining() || gate.holds(state, nodeState) || !gate.Filter.Allows(nodeState) {
+			continue
+		}
+		nodeStep := stepNumberForNode(nodeState, gate.Goal)
+		if inFlightOnly && (nodeStep == 0 || nodeStep == len(gate.Pipelines.For(nodeState))) {
 			continue
 		}
-		nodeStep := stepNumberForNode(nodeState, targetRevision)
 		if nodeStep < lowestStep {
 			lowestStep = nodeStep
 		}
@@ -520,38 +1204,44 @@ func lowestStepForCluster(state State, clusterNum, targetRevision int) int {
 	return lowestStep
 }
 
-func getDownableCluster(startingState State, targetRevision int) int {
-	downClusters := make(map[int]bool)
-	wrongRevClusters := make(map[int]bool)
-	for _, nodeState := range startingState {
-		if !nodeState.InLoadbalancerPool {
-			downClusters[nodeState.Cluster] = true
+// getDownableGroup returns the value of the given label shared by the nodes
+// which may be taken down, and false if none may be. Nodes without the label
+// make up a group of their own, whose value is empty.
+func getDownableGroup(startingState State, label string, gate startGate) (string, bool) {
+	downGroups := make(map[string]bool)
+	wrongRevGroups := make(map[string]bool)
+	for _, nodeState := range startingState.Nodes {
+		if nodeState.down() {
+			downGroups[nodeState.Label(label)] = true
 		}
-		if nodeState.SoftwareRevision != targetRevision {
-			wrongRevClusters[nodeState.Cluster] = true
+		// nodes held back by the gate can't start yet, and failed nodes and
+		// those its filter doesn't allow never will, so they don't make their
+		// group a candidate
+		if !nodeState.upToDate(gate.Goal) && !nodeState.failed() && !gate.holds(startingState, nodeState) && gate.Filter.Allows(nodeState) {
+			wrongRevGroups[nodeState.Label(label)] = true
 		}
 	}
-	// if more than one cluster is down then we can't proceed safely; return -1
-	if len(downClusters) > 1 {
-		return -1
+	// if more than one group is down then we can't proceed safely
+	if len(downGroups) > 1 {
+		return "", false
 	}
-	// prefer any cluster which already has down nodes
-	if len(downClusters) > 0 {
-		for clusterNum := range downClusters {
-			return clusterNum
+	// prefer any group which already has down nodes
+	if len(downGroups) > 0 {
+		for groupValue := range downGroups {
+			return groupValue, true
 		}
 	}
-	// fall back to lowest numbered cluster which has at least one node not at
-	// the target revision
-	if len(wrongRevClusters) > 0 {
-		wrongRevClusterSlice := make([]int, 0, len(wrongRevClusters))
-		for clusterNum := range wrongRevClusters {
-			wrongRevClusterSlice = append(wrongRevClusterSlice, clusterNum)
+	// fall back to lowest sorted group which has at least one node not at
+	// the target revision and free to start
+	if len(wrongRevGroups) > 0 {
+		wrongRevGroupSlice := make([]string, 0, len(wrongRevGroups))
+		for groupValue := range wrongRevGroups {
+			wrongRevGroupSlice = append(wrongRevGroupSlice, groupValue)
 		}
-		sort.Ints(wrongRevClusterSlice)
-		return wrongRevClusterSlice[0]
+		sortLabelValues(wrongRevGroupSlice)
+		return wrongRevGroupSlice[0], true
 	}
-	// otherwise return -1 to indicate that we shouldn't take any groups down,
-	// because they're all up + at the target revision
-	return -1
+	// otherwise we shouldn't take any groups down, because they're all up +
+	// at the target revision
+	return "", false
 }
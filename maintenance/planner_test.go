@@ -8,131 +8,190 @@ import (
 	"testing"
 )
 
-func Test_getDownableCluster(t *testing.T) {
+func Test_getDownableGroup(t *testing.T) {
 	t.Parallel()
 
 	testCases := map[string]struct {
 		startingState  State
 		targetRevision int
-		expected       int
+		label          string
+		dependencies   Dependencies
+		expected       string
+		expectNone     bool
 	}{
 		"default to 1": {
-			startingState: State{
+			startingState: State{Nodes: []NodeState{
 				NodeState{
-					Name:               "app1-1",
-					Cluster:            1,
-					SoftwareRevision:   1,
-					AppRunning:         true,
-					InLoadbalancerPool: true,
-					CacheWarmed:        true,
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
 				},
 				NodeState{
-					Name:               "app2-1",
-					Cluster:            2,
-					SoftwareRevision:   1,
-					AppRunning:         true,
-					InLoadbalancerPool: true,
-					CacheWarmed:        true,
+					Name:             "app2-1",
+					Cluster:          2,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
 				},
-			},
+			}},
 			targetRevision: 2,
-			expected:       1,
+			expected:       "1",
 		},
 		"detect 2": {
-			startingState: State{
+			startingState: State{Nodes: []NodeState{
 				NodeState{
-					Name:               "app1-1",
-					Cluster:            1,
-					SoftwareRevision:   1,
-					AppRunning:         true,
-					InLoadbalancerPool: true,
-					CacheWarmed:        true,
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
 				},
 				NodeState{
-					Name:               "app2-1",
-					Cluster:            2,
-					SoftwareRevision:   1,
-					AppRunning:         false,
-					InLoadbalancerPool: false,
-					CacheWarmed:        false,
+					Name:             "app2-1",
+					Cluster:          2,
+					SoftwareRevision: 1,
+					AppRunning:       false,
+					PoolWeight:       0,
+					CacheWarmed:      false,
 				},
-			},
+			}},
 			targetRevision: 2,
-			expected:       2,
+			expected:       "2",
 		},
 		"select 2 when 1 already at correct revision": {
-			startingState: State{
+			startingState: State{Nodes: []NodeState{
 				NodeState{
-					Name:               "app1-1",
-					Cluster:            1,
-					SoftwareRevision:   2,
-					AppRunning:         true,
-					InLoadbalancerPool: true,
-					CacheWarmed:        true,
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 2,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
 				},
 				NodeState{
-					Name:               "app2-1",
-					Cluster:            2,
-					SoftwareRevision:   1,
-					AppRunning:         false,
-					InLoadbalancerPool: false,
-					CacheWarmed:        false,
+					Name:             "app2-1",
+					Cluster:          2,
+					SoftwareRevision: 1,
+					AppRunning:       false,
+					PoolWeight:       0,
+					CacheWarmed:      false,
 				},
-			},
+			}},
 			targetRevision: 2,
-			expected:       2,
+			expected:       "2",
 		},
-		"return -1 when more than one cluster is down": {
-			startingState: State{
+		"none when more than one cluster is down": {
+			startingState: State{Nodes: []NodeState{
 				NodeState{
-					Name:               "app1-1",
-					Cluster:            1,
-					SoftwareRevision:   2,
-					AppRunning:         false,
-					InLoadbalancerPool: false,
-					CacheWarmed:        false,
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 2,
+					AppRunning:       false,
+					PoolWeight:       0,
+					CacheWarmed:      false,
 				},
 				NodeState{
-					Name:               "app2-1",
-					Cluster:            2,
-					SoftwareRevision:   1,
-					AppRunning:         false,
-					InLoadbalancerPool: false,
-					CacheWarmed:        false,
+					Name:             "app2-1",
+					Cluster:          2,
+					SoftwareRevision: 1,
+					AppRunning:       false,
+					PoolWeight:       0,
+					CacheWarmed:      false,
 				},
-			},
+			}},
+			targetRevision: 2,
+			expectNone:     true,
+		},
+		"none when all clusters up and at target rev": {
+			startingState: State{Nodes: []NodeState{
+				NodeState{
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 2,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
+				},
+				NodeState{
+					Name:             "app2-1",
+					Cluster:          2,
+					SoftwareRevision: 2,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
+				},
+			}},
 			targetRevision: 2,
-			expected:       -1,
+			expectNone:     true,
 		},
-		"return -1 when all clusters up and at target rev": {
-			startingState: State{
+		"skip 1 while it waits on dependencies in 2": {
+			startingState: State{Nodes: []NodeState{
 				NodeState{
-					Name:               "app1-1",
-					Cluster:            1,
-					SoftwareRevision:   2,
-					AppRunning:         true,
-					InLoadbalancerPool: true,
-					CacheWarmed:        true,
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
 				},
 				NodeState{
-					Name:               "app2-1",
-					Cluster:            2,
-					SoftwareRevision:   2,
-					AppRunning:         true,
-					InLoadbalancerPool: true,
-					CacheWarmed:        true,
+					Name:             "db2-1",
+					Cluster:          2,
+					Role:             "stateful",
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
 				},
+			}},
+			targetRevision: 2,
+			dependencies: Dependencies{
+				{Label: RoleLabel, Group: "app", DependsOn: "stateful"},
 			},
+			expected: "2",
+		},
+		"unlabelled nodes are a group of their own": {
+			startingState: State{Nodes: []NodeState{
+				NodeState{
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 2,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
+					Labels:           map[string]string{"zone": "a"},
+				},
+				NodeState{
+					Name:             "app1-2",
+					Cluster:          1,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
+				},
+			}},
 			targetRevision: 2,
-			expected:       -1,
+			label:          "zone",
+			expected:       "",
 		},
 	}
 
 	for testName, tc := range testCases {
 		t.Run(testName, func(t *testing.T) {
-			actual := getDownableCluster(tc.startingState, tc.targetRevision)
+			label := tc.label
+			if label == "" {
+				label = ClusterLabel
+			}
+			actual, ok := getDownableGroup(tc.startingState, label, startGate{Goal: Goal{TargetRevision: tc.targetRevision}, Dependencies: tc.dependencies})
+			if ok == tc.expectNone {
+				t.Errorf("expected a downable group: %t, got %t", !tc.expectNone, ok)
+			}
 			if actual != tc.expected {
-				t.Errorf("expected %d, got %d", tc.expected, actual)
+				t.Errorf("expected %q, got %q", tc.expected, actual)
 			}
 		})
 	}
@@ -143,109 +202,238 @@ func Test_stepNumberForNode(t *testing.T) {
 	testCases := map[string]struct {
 		nodeState      NodeState
 		targetRevision int
+		osPatchLevel   int
+		config         ConfigChange
 		expected       int
 	}{
 		"step 0 unambiguous": {
 			nodeState: NodeState{
-				Name:               "app1-1",
-				Cluster:            1,
-				SoftwareRevision:   1,
-				AppRunning:         true,
-				InLoadbalancerPool: true,
-				CacheWarmed:        true,
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 1,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
 			},
 			targetRevision: 2,
 			expected:       0,
 		},
 		"step 0 ambiguous": {
 			nodeState: NodeState{
-				Name:               "app1-1",
-				Cluster:            1,
-				SoftwareRevision:   1,
-				AppRunning:         false,
-				InLoadbalancerPool: true,
-				CacheWarmed:        false,
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 1,
+				AppRunning:       false,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      false,
 			},
 			targetRevision: 2,
 			expected:       0,
 		},
 		"step 1 unambiguous": {
 			nodeState: NodeState{
-				Name:               "app1-1",
-				Cluster:            1,
-				SoftwareRevision:   1,
-				AppRunning:         true,
-				InLoadbalancerPool: false,
-				CacheWarmed:        true,
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 1,
+				AppRunning:       true,
+				PoolWeight:       0,
+				CacheWarmed:      true,
 			},
 			targetRevision: 2,
 			expected:       1,
 		},
 		"step 2 unambiguous": {
 			nodeState: NodeState{
-				Name:               "app1-1",
-				Cluster:            1,
-				SoftwareRevision:   1,
-				AppRunning:         false,
-				InLoadbalancerPool: false,
-				CacheWarmed:        false,
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 1,
+				AppRunning:       false,
+				PoolWeight:       0,
+				CacheWarmed:      false,
 			},
 			targetRevision: 2,
 			expected:       2,
 		},
+		"step 0 needing reboot": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
+				Healthy:          true,
+				NeedsReboot:      true,
+			},
+			targetRevision: 2,
+			expected:       0,
+		},
 		"step 3 unambiguous": {
 			nodeState: NodeState{
-				Name:               "app1-1",
-				Cluster:            1,
-				SoftwareRevision:   2,
-				AppRunning:         false,
-				InLoadbalancerPool: false,
-				CacheWarmed:        false,
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       false,
+				PoolWeight:       0,
+				CacheWarmed:      false,
+				ConfigVersion:    1,
+			},
+			targetRevision: 2,
+			config:         ConfigChange{Version: 2},
+			expected:       3,
+		},
+		"step 3 hot reload in pool": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
+				Healthy:          true,
+				ConfigVersion:    1,
 			},
 			targetRevision: 2,
+			config:         ConfigChange{Version: 2, HotReload: true},
 			expected:       3,
 		},
+		"step 0 needing restart for config": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
+				Healthy:          true,
+				ConfigVersion:    1,
+			},
+			targetRevision: 2,
+			config:         ConfigChange{Version: 2},
+			expected:       0,
+		},
 		"step 4 unambiguous": {
 			nodeState: NodeState{
-				Name:               "app1-1",
-				Cluster:            1,
-				SoftwareRevision:   2,
-				AppRunning:         true,
-				InLoadbalancerPool: false,
-				CacheWarmed:        false,
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       false,
+				PoolWeight:       0,
+				CacheWarmed:      false,
+				OSPatchLevel:     1,
 			},
 			targetRevision: 2,
+			osPatchLevel:   2,
 			expected:       4,
 		},
 		"step 5 unambiguous": {
 			nodeState: NodeState{
-				Name:               "app1-1",
-				Cluster:            1,
-				SoftwareRevision:   2,
-				AppRunning:         true,
-				InLoadbalancerPool: false,
-				CacheWarmed:        true,
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       false,
+				PoolWeight:       0,
+				CacheWarmed:      false,
+				OSPatchLevel:     2,
+				NeedsReboot:      true,
 			},
 			targetRevision: 2,
+			osPatchLevel:   2,
 			expected:       5,
 		},
 		"step 6 unambiguous": {
 			nodeState: NodeState{
-				Name:               "app1-1",
-				Cluster:            1,
-				SoftwareRevision:   2,
-				AppRunning:         true,
-				InLoadbalancerPool: true,
-				CacheWarmed:        true,
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       false,
+				PoolWeight:       0,
+				CacheWarmed:      false,
 			},
 			targetRevision: 2,
 			expected:       6,
 		},
+		"step 7 unambiguous": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       0,
+				CacheWarmed:      false,
+			},
+			targetRevision: 2,
+			expected:       7,
+		},
+		"step 7 with cache already warm": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       0,
+				CacheWarmed:      true,
+			},
+			targetRevision: 2,
+			expected:       7,
+		},
+		"step 7 after failed health check": {
+			nodeState: NodeState{
+				Name:              "app1-1",
+				Cluster:           1,
+				SoftwareRevision:  2,
+				AppRunning:        true,
+				PoolWeight:        0,
+				CacheWarmed:       false,
+				HealthCheckFailed: true,
+			},
+			targetRevision: 2,
+			expected:       7,
+		},
+		"step 8 unambiguous": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       0,
+				CacheWarmed:      false,
+				Healthy:          true,
+			},
+			targetRevision: 2,
+			expected:       8,
+		},
+		"step 9 unambiguous": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       0,
+				CacheWarmed:      true,
+				Healthy:          true,
+			},
+			targetRevision: 2,
+			expected:       9,
+		},
+		"step 10 unambiguous": {
+			nodeState: NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 2,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
+				Healthy:          true,
+			},
+			targetRevision: 2,
+			expected:       10,
+		},
 	}
 
 	for testName, tc := range testCases {
 		t.Run(testName, func(t *testing.T) {
-			actual := stepNumberForNode(tc.nodeState, tc.targetRevision)
+			actual := stepNumberForNode(tc.nodeState, Goal{TargetRevision: tc.targetRevision, OSPatchLevel: tc.osPatchLevel, Config: tc.config})
 			if actual != tc.expected {
 				t.Errorf("expected %d, got %d", tc.expected, actual)
 			}
@@ -253,43 +441,68 @@ func Test_stepNumberForNode(t *testing.T) {
 	}
 }
 
-func Test_lowestStepForCluster(t *testing.T) {
+func Test_lowestStepForGroup(t *testing.T) {
 	t.Parallel()
 
 	testCases := map[string]struct {
 		startingState  State
 		targetRevision int
-		clusterNum     int
+		groupValue     string
+		inFlightOnly   bool
 		expected       int
 	}{
 		"expect-0": {
-			startingState: State{
+			startingState: State{Nodes: []NodeState{
 				NodeState{
-					Name:               "app1-1",
-					Cluster:            1,
-					SoftwareRevision:   1,
-					AppRunning:         true,
-					InLoadbalancerPool: true,
-					CacheWarmed:        true,
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
 				},
 				NodeState{
-					Name:               "app1-1",
-					Cluster:            1,
-					SoftwareRevision:   1,
-					AppRunning:         true,
-					InLoadbalancerPool: false,
-					CacheWarmed:        true,
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       0,
+					CacheWarmed:      true,
 				},
-			},
+			}},
 			targetRevision: 2,
-			clusterNum:     1,
+			groupValue:     "1",
 			expected:       0,
 		},
+		"expect-1-in-flight-only": {
+			startingState: State{Nodes: []NodeState{
+				NodeState{
+					Name:             "app1-1",
+					Cluster:          1,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       FullWeight,
+					CacheWarmed:      true,
+				},
+				NodeState{
+					Name:             "app1-2",
+					Cluster:          1,
+					SoftwareRevision: 1,
+					AppRunning:       true,
+					PoolWeight:       0,
+					CacheWarmed:      true,
+				},
+			}},
+			targetRevision: 2,
+			groupValue:     "1",
+			inFlightOnly:   true,
+			expected:       1,
+		},
 	}
 
 	for testName, tc := range testCases {
 		t.Run(testName, func(t *testing.T) {
-			actual := lowestStepForCluster(tc.startingState, tc.clusterNum, tc.targetRevision)
+			actual := lowestStepForGroup(tc.startingState, ClusterLabel, tc.groupValue, DefaultRole, startGate{Goal: Goal{TargetRevision: tc.targetRevision}}, tc.inFlightOnly)
 			if actual != tc.expected {
 				t.Errorf("expected %d, got %d", tc.expected, actual)
 			}
@@ -297,42 +510,78 @@ func Test_lowestStepForCluster(t *testing.T) {
 	}
 }
 
-func ExampleMaintenancePlanner() {
+func TestPlanner_blockedByFailedHealthCheck(t *testing.T) {
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:              "app1-1",
+			Cluster:           1,
+			SoftwareRevision:  2,
+			AppRunning:        true,
+			PoolWeight:        0,
+			CacheWarmed:       false,
+			HealthCheckFailed: true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+
+	blocked := BlockedNodes(startingState, Goal{TargetRevision: 2})
+	if len(blocked) != 1 || blocked[0] != "app1-1" {
+		t.Errorf("expected [app1-1] to be blocked, got %v", blocked)
+	}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{}
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+	if plan != nil {
+		t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+	}
+}
+
+func ExamplePlanner() {
 	log.SetFlags(0)
-	startingState := State{
+	startingState := State{Nodes: []NodeState{
 		NodeState{
-			Name:               "app1-1",
-			Cluster:            1,
-			SoftwareRevision:   1,
-			AppRunning:         true,
-			InLoadbalancerPool: true,
-			CacheWarmed:        true,
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
 		},
 		NodeState{
-			Name:               "app1-2",
-			Cluster:            1,
-			SoftwareRevision:   1,
-			AppRunning:         true,
-			InLoadbalancerPool: true,
-			CacheWarmed:        true,
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
 		},
 		NodeState{
-			Name:               "app2-1",
-			Cluster:            2,
-			SoftwareRevision:   1,
-			AppRunning:         false,
-			InLoadbalancerPool: false,
-			CacheWarmed:        false,
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       false,
+			PoolWeight:       0,
+			CacheWarmed:      false,
 		},
 		NodeState{
-			Name:               "app2-2",
-			Cluster:            2,
-			SoftwareRevision:   1,
-			AppRunning:         true,
-			InLoadbalancerPool: false,
-			CacheWarmed:        true,
+			Name:             "app2-2",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       0,
+			CacheWarmed:      true,
 		},
-	}
+	}}
 
 	log.SetOutput(ioutil.Discard)
 	mp := &Planner{}
@@ -346,22 +595,26 @@ func ExampleMaintenancePlanner() {
 	// Stop app: app2-2
 	// Update software: app2-1
 	// Update software: app2-2
-	// Start app: app2-2
 	// Start app: app2-1
-	// Warm cache: app2-2
+	// Start app: app2-2
+	// Health check: app2-1
+	// Health check: app2-2
 	// Warm cache: app2-1
-	// Add node to pool: app2-2
+	// Warm cache: app2-2
 	// Add node to pool: app2-1
-	// Drain node from pool: app1-2
+	// Add node to pool: app2-2
 	// Drain node from pool: app1-1
-	// Stop app: app1-2
+	// Drain node from pool: app1-2
 	// Stop app: app1-1
-	// Update software: app1-2
+	// Stop app: app1-2
 	// Update software: app1-1
-	// Start app: app1-2
+	// Update software: app1-2
 	// Start app: app1-1
-	// Warm cache: app1-2
+	// Start app: app1-2
+	// Health check: app1-1
+	// Health check: app1-2
 	// Warm cache: app1-1
-	// Add node to pool: app1-2
+	// Warm cache: app1-2
 	// Add node to pool: app1-1
+	// Add node to pool: app1-2
 }
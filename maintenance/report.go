@@ -0,0 +1,75 @@
+package maintenance
+
+import (
+	"time"
+)
+
+// A PlanSummary describes what executing a staged plan would be like, so
+// that strategies can be compared on more than cost.
+type PlanSummary struct {
+	Actions int
+	Stages  int
+	// Duration estimates the wall-clock time to run every stage in turn,
+	// each taking as long as its slowest action.
+	Duration time.Duration
+	// MinInPool is the fewest nodes in the load-balancer pool between any
+	// two stages.
+	MinInPool int
+	// MinInPoolCapacity is the least capacity in the pool between any two
+	// stages, in requests per second.
+	MinInPoolCapacity float64
+	// MixedRevisionTime is how long nodes in the pool run more than one
+	// revision.
+	MixedRevisionTime time.Duration
+}
+
+// SummarisePlan works out a PlanSummary for stages planned from the given
+// state. Empty durations are replaced by DefaultDurations.
+func SummarisePlan(startingState State, stages []Stage, durations Durations) PlanSummary {
+	if durations.isEmpty() {
+		durations = DefaultDurations()
+	}
+
+	summary := PlanSummary{
+		Stages:    len(stages),
+		MinInPool: inPoolCount(startingState),
+	}
+	summary.MinInPoolCapacity, _ = inPoolCapacity(startingState)
+	state := startingState
+	for _, stage := range stages {
+		var stageDuration time.Duration
+		for _, action := range stage {
+			if d := durations.For(action); d > stageDuration {
+				stageDuration = d
+			}
+		}
+		summary.Actions += len(stage)
+		summary.Duration += stageDuration
+		if mixedRevisions(state) {
+			summary.MixedRevisionTime += stageDuration
+		}
+
+		for _, action := range stage {
+			if rebased, ok := rebaseAction(nil, state, action); ok {
+				state = rebased.FinalState()
+			}
+		}
+		if n := inPoolCount(state); n < summary.MinInPool {
+			summary.MinInPool = n
+		}
+		if capacity, _ := inPoolCapacity(state); capacity < summary.MinInPoolCapacity {
+			summary.MinInPoolCapacity = capacity
+		}
+	}
+	return summary
+}
+
+func inPoolCount(state State) int {
+	var n int
+	for _, nodeState := range state.Nodes {
+		if nodeState.serving() {
+			n++
+		}
+	}
+	return n
+}
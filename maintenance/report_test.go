@@ -0,0 +1,36 @@
+package maintenance
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSummarisePlan(t *testing.T) {
+	startingState := State{Nodes: []NodeState{
+		{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true},
+		{Name: "app2-1", Cluster: 2, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true},
+	}}
+	durations := Durations{Default: time.Minute}
+
+	mp := &Planner{}
+	log.SetOutput(ioutil.Discard)
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	// app1-1 is upgraded first, leaving the pool mixed until app2-1 has
+	// been drained
+	expected := PlanSummary{
+		Actions:           14,
+		Stages:            14,
+		Duration:          14 * time.Minute,
+		MinInPool:         1,
+		MixedRevisionTime: time.Minute,
+	}
+	summary := SummarisePlan(startingState, stages, durations)
+	if summary != expected {
+		t.Errorf("expected %+v, got %+v", expected, summary)
+	}
+}
@@ -0,0 +1,321 @@
+package maintenance
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// Durations configures how long each kind of action takes to execute.
+type Durations struct {
+	// Default applies to any action kind not listed in ByKind.
+	Default time.Duration `yaml:"default"`
+	// ByKind gives the duration of each kind of action.
+	ByKind map[ActionKind]time.Duration `yaml:"bykind"`
+	// ByNode overrides ByKind for individual nodes, keyed by node name.
+	ByNode map[string]map[ActionKind]time.Duration `yaml:"bynode"`
+}
+
+// DefaultDurations returns the durations used when a Planner isn't given any.
+func DefaultDurations() Durations {
+	return Durations{
+		Default: time.Minute,
+		ByKind: map[ActionKind]time.Duration{
+			KindDrainNodeFromPool:      5 * time.Second,
+			KindStopApp:                10 * time.Second,
+			KindUpdateSoftwareRevision: time.Minute,
+			KindStartApp:               20 * time.Second,
+			KindHealthCheck:            15 * time.Second,
+			KindCatchUpReplication:     2 * time.Minute,
+			KindWarmCache:              10 * time.Minute,
+			KindAddNodeToPool:          5 * time.Second,
+			KindExpandSchema:           5 * time.Minute,
+			KindContractSchema:         5 * time.Minute,
+			KindPauseForVerification:   15 * time.Minute,
+			KindRemoveFromPool:         5 * time.Second,
+			KindMarkForReplacement:     5 * time.Second,
+			KindProvisionReplacement:   10 * time.Minute,
+			KindProvisionNode:          10 * time.Minute,
+			KindDecommissionNode:       time.Minute,
+			KindPatchOS:                2 * time.Minute,
+			KindRebootNode:             3 * time.Minute,
+			KindUpdateConfig:           10 * time.Second,
+			KindReloadConfig:           5 * time.Second,
+			KindRampWeight:             time.Minute,
+			KindFailoverClusterTraffic: time.Minute,
+			KindRestoreClusterTraffic:  time.Minute,
+		},
+	}
+}
+
+// For returns how long the given action is expected to take. Waits for
+// connections to drain are expected to take their whole timeout, unless
+// ByNode or ByKind say otherwise.
+func (d Durations) For(action MaintenanceAction) time.Duration {
+	if byKind, found := d.ByNode[action.NodeName()]; found {
+		if duration, found := byKind[action.Kind()]; found {
+			return duration
+		}
+	}
+	if duration, found := d.ByKind[action.Kind()]; found {
+		return duration
+	}
+	if wfda, ok := action.(*WaitForDrainAction); ok && wfda.Timeout > 0 {
+		return wfda.Timeout
+	}
+	return d.Default
+}
+
+// costModel returns a CostModel charging each action the seconds it's
+// expected to take, so that planning with it minimises the total time spent
+// on actions. Waits for connections to drain are expected to take the given
+// timeout, as in For.
+func (d Durations) costModel(drainTimeout time.Duration) CostModel {
+	cm := CostModel{ByKind: make(map[ActionKind]float64)}
+	for kind := range knownActionKinds {
+		duration, found := d.ByKind[kind]
+		if !found {
+			duration = d.Default
+			if kind == KindWaitForDrain && drainTimeout > 0 {
+				duration = drainTimeout
+			}
+		}
+		cm.ByKind[kind] = duration.Seconds()
+	}
+	if len(d.ByNode) > 0 {
+		cm.byNode = make(map[string]map[ActionKind]float64)
+		for name, byKind := range d.ByNode {
+			cm.byNode[name] = make(map[ActionKind]float64)
+			for kind, duration := range byKind {
+				cm.byNode[name][kind] = duration.Seconds()
+			}
+		}
+	}
+	return cm
+}
+
+func (d Durations) isEmpty() bool {
+	return d.Default == 0 && len(d.ByKind) == 0 && len(d.ByNode) == 0
+}
+
+// A ScheduledAction is an action along with when it should start and end,
+// relative to the beginning of the rollout.
+type ScheduledAction struct {
+	Action MaintenanceAction
+	Start  time.Duration
+	End    time.Duration
+}
+
+// A Schedule is a plan whose actions may overlap in time.
+type Schedule []ScheduledAction
+
+// Makespan returns the wall-clock time needed to execute the whole schedule.
+func (s Schedule) Makespan() time.Duration {
+	var makespan time.Duration
+	for _, sa := range s {
+		if sa.End > makespan {
+			makespan = sa.End
+		}
+	}
+	return makespan
+}
+
+// PlanScheduleForTargetRevision is the temporal counterpart to
+// PlanActionsForTargetRevision. Instead of keeping every node in a cluster at
+// the same step, it lets each node progress independently and starts every
+// action as early as the constraints allow, so that actions overlap.
+//
+// The plan is searched for with costs taken from Durations in place of the
+// CostModel, so it's the one which spends the least time on actions in
+// total. It's then packed into a schedule, each action starting as soon as
+// the actions it depends on are done; packing isn't part of the search, so
+// a plan needing more time in total could, in principle, overlap into a
+// shorter makespan.
+//
+// Durations are taken from the Planner's Durations, or DefaultDurations if
+// those are empty. With a Calendar, actions are also kept within their
+// cluster's maintenance windows, and drains wait until the forecast demand
+// can be served without the node.
+func (p *Planner) PlanScheduleForTargetRevision(startingState State, targetSoftwareRevision int) Schedule {
+	// the step-sync rule only exists to make plans easy to parallelise, and
+	// the scheduler below does that properly, so it would just get in the
+	// way. A demand curve likewise replaces the State's fixed Demand.
+	var constraints []Constraint
+	for _, constraint := range p.constraintsForTargetRevision(targetSoftwareRevision) {
+		if _, ok := constraint.(*GroupStepSyncConstraint); ok {
+			continue
+		}
+		if _, ok := constraint.(*CapacityConstraint); ok && p.Calendar != nil && len(p.Calendar.DemandCurve) > 0 {
+			continue
+		}
+		constraints = append(constraints, constraint)
+	}
+	if p.Calendar != nil {
+		err := p.Calendar.Validate()
+		if err != nil {
+			log.Printf("Refusing to plan with invalid calendar: %s\n", err)
+			return nil
+		}
+	}
+
+	durations := p.Durations
+	if durations.isEmpty() {
+		durations = DefaultDurations()
+	}
+
+	timed := *p
+	timed.Costs = durations.costModel(p.Drain.Timeout)
+	plan := timed.planActions(startingState, targetSoftwareRevision, constraints)
+	if plan == nil {
+		return nil
+	}
+
+	var place func(Schedule, int, time.Duration) (time.Duration, error)
+	if p.Calendar != nil {
+		place = p.Calendar.placer(startingState, plan, durations, p.SafetyFactor)
+	}
+	schedule, err := scheduleActions(constraints, startingState, plan, durations, place)
+	if err != nil {
+		log.Printf("Refusing to plan; unable to schedule: %s\n", err)
+		return nil
+	}
+	return schedule
+}
+
+// scheduleActions relaxes a sequential plan into a partial order and
+// schedules each action at the earliest time its predecessors in that order
+// allow.
+//
+// Action i depends on an earlier action j if both target the same node, or if
+// i would be invalid (or violate a constraint) were j, and anything relying
+// on j, left out of the plan. The resulting schedule is then simulated in
+// order of completion, and any action which turns out to be unsafe given
+// what's running alongside it is made to wait for its overlapping peers.
+//
+// If place is given, it may delay each action beyond the earliest time its
+// predecessors allow, or fail the schedule altogether.
+//
+// Finding the dependencies replays the plan once for every pair of actions,
+// so takes time cubic in the length of the plan.
+func scheduleActions(constraints []Constraint, startingState State, plan []MaintenanceAction, durations Durations, place func(schedule Schedule, i int, earliest time.Duration) (time.Duration, error)) (Schedule, error) {
+	deps := make([]map[int]bool, len(plan))
+	for i, action := range plan {
+		deps[i] = make(map[int]bool)
+		for j := 0; j < i; j++ {
+			if plan[j].NodeName() == action.NodeName() {
+				deps[i][j] = true
+				continue
+			}
+			state := startingState
+			for k := 0; k < i; k++ {
+				if k == j {
+					continue
+				}
+				rebased, ok := rebaseAction(constraints, state, plan[k])
+				if ok {
+					state = rebased.FinalState()
+				}
+			}
+			if _, ok := rebaseAction(constraints, state, action); !ok {
+				deps[i][j] = true
+			}
+		}
+	}
+
+	schedule := make(Schedule, len(plan))
+	for {
+		for i, action := range plan {
+			var start time.Duration
+			for j := range deps[i] {
+				if schedule[j].End > start {
+					start = schedule[j].End
+				}
+			}
+			if place != nil {
+				var err error
+				start, err = place(schedule, i, start)
+				if err != nil {
+					return nil, err
+				}
+			}
+			schedule[i] = ScheduledAction{
+				Action: action,
+				Start:  start,
+				End:    start + durations.For(action),
+			}
+		}
+
+		if !serializeUnsafeOverlaps(constraints, startingState, schedule, deps) {
+			break
+		}
+	}
+
+	sort.SliceStable(schedule, func(i, j int) bool {
+		return schedule[i].Start < schedule[j].Start
+	})
+	return schedule, nil
+}
+
+// serializeUnsafeOverlaps simulates a schedule, checking each action both
+// against the state when it starts and the state when it completes. When an
+// action fails either check, new dependencies are added between it and the
+// actions overlapping it; the return value indicates whether that happened.
+func serializeUnsafeOverlaps(constraints []Constraint, startingState State, schedule Schedule, deps []map[int]bool) bool {
+	byEnd := make([]int, len(schedule))
+	for i := range byEnd {
+		byEnd[i] = i
+	}
+	sort.SliceStable(byEnd, func(a, b int) bool {
+		return schedule[byEnd[a]].End < schedule[byEnd[b]].End
+	})
+
+	serialize := func(i int) bool {
+		changed := false
+		for j := range schedule {
+			if j == i || schedule[j].End <= schedule[i].Start || schedule[j].Start >= schedule[i].End {
+				continue
+			}
+			if j < i && !deps[i][j] {
+				deps[i][j] = true
+				changed = true
+			}
+			if j > i && !deps[j][i] {
+				deps[j][i] = true
+				changed = true
+			}
+		}
+		return changed
+	}
+
+	// check each action against the state when it starts
+	for i, sa := range schedule {
+		state := startingState
+		for _, j := range byEnd {
+			if schedule[j].End > sa.Start {
+				break
+			}
+			if rebased, ok := rebaseAction(constraints, state, schedule[j].Action); ok {
+				state = rebased.FinalState()
+			}
+		}
+		if _, ok := rebaseAction(constraints, state, sa.Action); !ok {
+			if serialize(i) {
+				return true
+			}
+		}
+	}
+
+	// check each action against the state when it completes
+	state := startingState
+	for _, i := range byEnd {
+		rebased, ok := rebaseAction(constraints, state, schedule[i].Action)
+		if !ok {
+			if serialize(i) {
+				return true
+			}
+			continue
+		}
+		state = rebased.FinalState()
+	}
+	return false
+}
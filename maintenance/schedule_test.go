@@ -0,0 +1,113 @@
+package maintenance
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPlanner_PlanScheduleForTargetRevision(t *testing.T) {
+	upNode := func(name string, cluster int) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+		}
+	}
+
+	testCases := map[string]struct {
+		startingState    State
+		durations        Durations
+		expectedMakespan time.Duration
+	}{
+		"nodes in one cluster overlap": {
+			startingState: State{Nodes: []NodeState{
+				upNode("app1-1", 1),
+				upNode("app1-2", 1),
+			}},
+			durations:        Durations{Default: time.Minute},
+			expectedMakespan: 7 * time.Minute,
+		},
+		"clusters don't overlap": {
+			startingState: State{Nodes: []NodeState{
+				upNode("app1-1", 1),
+				upNode("app2-1", 2),
+			}},
+			durations:        Durations{Default: time.Minute},
+			expectedMakespan: 14 * time.Minute,
+		},
+		"slowest node dominates": {
+			startingState: State{Nodes: []NodeState{
+				upNode("app1-1", 1),
+				upNode("app1-2", 1),
+			}},
+			durations: Durations{
+				Default: time.Minute,
+				ByNode: map[string]map[ActionKind]time.Duration{
+					"app1-2": {KindWarmCache: 10 * time.Minute},
+				},
+			},
+			expectedMakespan: 16 * time.Minute,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			mp := &Planner{Durations: tc.durations}
+			log.SetOutput(ioutil.Discard)
+			schedule := mp.PlanScheduleForTargetRevision(tc.startingState, 2)
+			log.SetOutput(os.Stdout)
+
+			if len(schedule) != 7*len(tc.startingState.Nodes) {
+				t.Fatalf("expected %d actions, got %d", 7*len(tc.startingState.Nodes), len(schedule))
+			}
+			if schedule.Makespan() != tc.expectedMakespan {
+				t.Errorf("expected makespan %s, got %s", tc.expectedMakespan, schedule.Makespan())
+			}
+			for _, sa := range schedule {
+				if sa.End-sa.Start != tc.durations.For(sa.Action) {
+					t.Errorf("%s: expected duration %s, got %s", sa.Action, tc.durations.For(sa.Action), sa.End-sa.Start)
+				}
+			}
+		})
+	}
+}
+
+func TestPlanner_PlanScheduleForTargetRevision_durationCosts(t *testing.T) {
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+	// replacing the node takes fewer actions than upgrading it, but
+	// provisioning a new one takes far longer
+	mp := &Planner{Surge: &SurgePolicy{MaxSurge: 1}}
+
+	log.SetOutput(ioutil.Discard)
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	schedule := mp.PlanScheduleForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	if len(plan) == 0 || plan[0].Kind() != KindProvisionNode {
+		t.Errorf("expected the node to be replaced, got:\n%s", maintenanceActionList(plan))
+	}
+	for _, sa := range schedule {
+		if sa.Action.Kind() == KindProvisionNode {
+			t.Errorf("expected the node to be upgraded on schedule, got %s at +%s", sa.Action, sa.Start)
+		}
+	}
+	if expected := 11*time.Minute + 55*time.Second; schedule.Makespan() != expected {
+		t.Errorf("expected makespan %s, got %s", expected, schedule.Makespan())
+	}
+}
@@ -0,0 +1,159 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+// SchemaState tracks a fleet-wide expand/contract schema migration, by the
+// latest revision each phase has been run for.
+type SchemaState struct {
+	// ExpandedFor is the latest revision whose additive schema changes
+	// (new tables, columns and so on) have been applied.
+	ExpandedFor int `yaml:"expandedfor"`
+	// ContractedFor is the latest revision for which schema only needed by
+	// older revisions has been removed.
+	ContractedFor int `yaml:"contractedfor"`
+}
+
+// ExpandSchemaAction applies the target revision's additive schema changes.
+// It must run before any node of the target revision serves traffic, so it
+// isn't offered once one does.
+type ExpandSchemaAction struct {
+	TargetRevision int
+	finalState     State
+}
+
+func (esa *ExpandSchemaAction) String() string {
+	return fmt.Sprintf("Expand schema for revision %d", esa.TargetRevision)
+}
+
+func (esa *ExpandSchemaAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	if startingState.Schema.ExpandedFor >= esa.TargetRevision {
+		return nil
+	}
+	if len(servingBeforeExpansion(startingState, esa.TargetRevision)) > 0 {
+		return nil
+	}
+	newState := startingState
+	newState.Schema.ExpandedFor = esa.TargetRevision
+	return []MaintenanceAction{
+		&ExpandSchemaAction{
+			TargetRevision: esa.TargetRevision,
+			finalState:     newState,
+		},
+	}
+}
+
+func (esa *ExpandSchemaAction) FinalState() State {
+	return esa.finalState
+}
+
+// NodeName is empty, since the action applies to the whole fleet.
+func (esa *ExpandSchemaAction) NodeName() string {
+	return ""
+}
+
+func (esa *ExpandSchemaAction) Kind() ActionKind {
+	return KindExpandSchema
+}
+
+// ContractSchemaAction removes schema which only older revisions needed. It
+// may only run once the schema has been expanded and every node has left the
+// older revisions behind.
+type ContractSchemaAction struct {
+	TargetRevision int
+	finalState     State
+}
+
+func (csa *ContractSchemaAction) String() string {
+	return fmt.Sprintf("Contract schema for revision %d", csa.TargetRevision)
+}
+
+func (csa *ContractSchemaAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	if startingState.Schema.ContractedFor >= csa.TargetRevision || startingState.Schema.ExpandedFor < csa.TargetRevision {
+		return nil
+	}
+	for _, nodeState := range startingState.Nodes {
+		if nodeState.SoftwareRevision < csa.TargetRevision {
+			return nil
+		}
+	}
+	newState := startingState
+	newState.Schema.ContractedFor = csa.TargetRevision
+	return []MaintenanceAction{
+		&ContractSchemaAction{
+			TargetRevision: csa.TargetRevision,
+			finalState:     newState,
+		},
+	}
+}
+
+func (csa *ContractSchemaAction) FinalState() State {
+	return csa.finalState
+}
+
+// NodeName is empty, since the action applies to the whole fleet.
+func (csa *ContractSchemaAction) NodeName() string {
+	return ""
+}
+
+func (csa *ContractSchemaAction) Kind() ActionKind {
+	return KindContractSchema
+}
+
+// SchemaExpandedConstraint keeps nodes of the target revision out of the
+// load-balancer pool until the schema has been expanded for it, whichever
+// action would put them there.
+type SchemaExpandedConstraint struct {
+	TargetRevision int
+}
+
+func (sec *SchemaExpandedConstraint) Name() string {
+	return "schema-expanded"
+}
+
+func (sec *SchemaExpandedConstraint) Check(state State, action MaintenanceAction, nextState State) error {
+	already := make(map[string]bool)
+	for _, name := range servingBeforeExpansion(state, sec.TargetRevision) {
+		already[name] = true
+	}
+	for _, name := range servingBeforeExpansion(nextState, sec.TargetRevision) {
+		if !already[name] {
+			return fmt.Errorf("node %s would serve revision %d before the schema is expanded for it", name, nextState.Nodes[nextState.indexOfNode(name)].SoftwareRevision)
+		}
+	}
+	return nil
+}
+
+// servingBeforeExpansion returns the names of nodes already serving the
+// target revision, or a later one, although the schema hasn't been expanded
+// for it; a migration can't be planned around them.
+func servingBeforeExpansion(state State, targetRevision int) []string {
+	if state.Schema.ExpandedFor >= targetRevision {
+		return nil
+	}
+	var names []string
+	for _, nodeState := range state.Nodes {
+		if nodeState.inPool() && nodeState.SoftwareRevision >= targetRevision {
+			names = append(names, nodeState.Name)
+		}
+	}
+	return names
+}
+
+// schemaDone reports whether both phases of the migration have run.
+func schemaDone(state State, targetRevision int) bool {
+	return state.Schema.ExpandedFor >= targetRevision && state.Schema.ContractedFor >= targetRevision
+}
+
+// estimateSchema returns the cost of the migration actions still to run.
+func estimateSchema(state State, targetRevision int, costs CostModel) float64 {
+	var cost float64
+	if state.Schema.ExpandedFor < targetRevision {
+		cost += costs.costForKind(KindExpandSchema)
+	}
+	if state.Schema.ContractedFor < targetRevision {
+		cost += costs.costForKind(KindContractSchema)
+	}
+	return cost
+}
@@ -0,0 +1,266 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestState_YAML(t *testing.T) {
+	t.Parallel()
+
+	nodes := []NodeState{
+		{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+		},
+	}
+	testCases := map[string]struct {
+		state    State
+		expected string
+	}{
+		"nodes only": {
+			state: State{Nodes: nodes},
+			expected: `- name: app1-1
+  cluster: 1
+  softwarerevision: 1
+  apprunning: true
+  poolweight: 100
+  cachewarmed: true
+`,
+		},
+		"with schema": {
+			state: State{Nodes: nodes, Schema: SchemaState{ExpandedFor: 2}},
+			expected: `nodes:
+- name: app1-1
+  cluster: 1
+  softwarerevision: 1
+  apprunning: true
+  poolweight: 100
+  cachewarmed: true
+schema:
+  expandedfor: 2
+  contractedfor: 0
+`,
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			outBytes, err := yaml.Marshal(tc.state)
+			if err != nil {
+				t.Fatalf("yaml.Marshal: %s", err)
+			}
+			if string(outBytes) != tc.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", tc.expected, outBytes)
+			}
+			var actual State
+			err = yaml.Unmarshal(outBytes, &actual)
+			if err != nil {
+				t.Fatalf("yaml.Unmarshal: %s", err)
+			}
+			if !reflect.DeepEqual(tc.state, actual) {
+				t.Errorf("expected %v, got %v", tc.state, actual)
+			}
+		})
+	}
+}
+
+func TestSchemaActions_preconditions(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, rev int, inPool bool) NodeState {
+		nodeState := NodeState{
+			Name:             name,
+			Cluster:          1,
+			SoftwareRevision: rev,
+			AppRunning:       true,
+			CacheWarmed:      true,
+			Healthy:          true,
+		}
+		if inPool {
+			nodeState.PoolWeight = FullWeight
+		}
+		return nodeState
+	}
+
+	testCases := map[string]struct {
+		state          State
+		expectExpand   bool
+		expectContract bool
+	}{
+		"nothing updated yet": {
+			state:        State{Nodes: []NodeState{node("app1-1", 1, true), node("app1-2", 1, true)}},
+			expectExpand: true,
+		},
+		"new revision waiting to serve": {
+			state:        State{Nodes: []NodeState{node("app1-1", 2, false), node("app1-2", 1, true)}},
+			expectExpand: true,
+		},
+		"new revision already serving": {
+			state: State{Nodes: []NodeState{node("app1-1", 2, true), node("app1-2", 1, true)}},
+		},
+		"old revision still present": {
+			state: State{
+				Nodes:  []NodeState{node("app1-1", 2, true), node("app1-2", 1, false)},
+				Schema: SchemaState{ExpandedFor: 2},
+			},
+		},
+		"old revision gone": {
+			state: State{
+				Nodes:  []NodeState{node("app1-1", 2, true), node("app1-2", 2, false)},
+				Schema: SchemaState{ExpandedFor: 2},
+			},
+			expectContract: true,
+		},
+		"not yet expanded": {
+			state: State{Nodes: []NodeState{node("app1-1", 2, false), node("app1-2", 2, false)}},
+			// expanding is still allowed, but contracting must wait for it
+			expectExpand: true,
+		},
+		"already contracted": {
+			state: State{
+				Nodes:  []NodeState{node("app1-1", 2, true), node("app1-2", 2, true)},
+				Schema: SchemaState{ExpandedFor: 2, ContractedFor: 2},
+			},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			expand := (&ExpandSchemaAction{TargetRevision: 2}).CloneForValidTargets(tc.state)
+			if (len(expand) > 0) != tc.expectExpand {
+				t.Errorf("expected expand to be valid: %t, got %v", tc.expectExpand, expand)
+			}
+			contract := (&ContractSchemaAction{TargetRevision: 2}).CloneForValidTargets(tc.state)
+			if (len(contract) > 0) != tc.expectContract {
+				t.Errorf("expected contract to be valid: %t, got %v", tc.expectContract, contract)
+			}
+		})
+	}
+}
+
+func TestSchemaExpandedConstraint(t *testing.T) {
+	t.Parallel()
+
+	node := func(name string, rev, weight int) NodeState {
+		return NodeState{Name: name, Cluster: 1, SoftwareRevision: rev, AppRunning: true, CacheWarmed: true, Healthy: true, PoolWeight: weight}
+	}
+	goal := Goal{TargetRevision: 2}
+
+	testCases := map[string]struct {
+		state       State
+		action      MaintenanceAction
+		expectError bool
+	}{
+		"add to pool before expansion": {
+			state:       State{Nodes: []NodeState{node("app1-1", 2, 0)}},
+			action:      &AddNodeToPoolAction{Goal: goal, RampStep: 25},
+			expectError: true,
+		},
+		"add to pool after expansion": {
+			state:  State{Nodes: []NodeState{node("app1-1", 2, 0)}, Schema: SchemaState{ExpandedFor: 2}},
+			action: &AddNodeToPoolAction{Goal: goal, RampStep: 25},
+		},
+		"drain old revision before expansion": {
+			state:  State{Nodes: []NodeState{node("app1-1", 1, FullWeight)}},
+			action: &DrainNodeFromPoolAction{Goal: goal},
+		},
+		"restore cluster traffic before expansion": {
+			state: State{
+				Nodes:   []NodeState{node("app1-1", 2, 0)},
+				Traffic: TrafficState{FailedOver: []int{1}},
+			},
+			action:      &RestoreClusterTrafficAction{Goal: goal},
+			expectError: true,
+		},
+		"restore cluster traffic after expansion": {
+			state: State{
+				Nodes:   []NodeState{node("app1-1", 2, 0)},
+				Traffic: TrafficState{FailedOver: []int{1}},
+				Schema:  SchemaState{ExpandedFor: 2},
+			},
+			action: &RestoreClusterTrafficAction{Goal: goal},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			constraint := &SchemaExpandedConstraint{TargetRevision: 2}
+			actions := tc.action.CloneForValidTargets(tc.state)
+			if len(actions) == 0 {
+				t.Fatalf("expected %T to be offered", tc.action)
+			}
+			for _, action := range actions {
+				err := constraint.Check(tc.state, action, action.FinalState())
+				if tc.expectError && err == nil {
+					t.Errorf("expected %q to be refused", action)
+				}
+				if !tc.expectError && err != nil {
+					t.Errorf("expected %q to be allowed, got %s", action, err)
+				}
+			}
+		})
+	}
+}
+
+func ExamplePlanner_schemaMigration() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+		NodeState{
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{SchemaMigration: true}
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for _, action := range plan {
+		fmt.Println(action)
+	}
+	// Output:
+	// Drain node from pool: app1-1
+	// Stop app: app1-1
+	// Update software: app1-1
+	// Start app: app1-1
+	// Health check: app1-1
+	// Warm cache: app1-1
+	// Expand schema for revision 2
+	// Add node to pool: app1-1
+	// Drain node from pool: app2-1
+	// Stop app: app2-1
+	// Update software: app2-1
+	// Start app: app2-1
+	// Health check: app2-1
+	// Warm cache: app2-1
+	// Add node to pool: app2-1
+	// Contract schema for revision 2
+}
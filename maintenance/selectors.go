@@ -0,0 +1,53 @@
+package maintenance
+
+// A NodeSelector picks out nodes by name, by cluster, or by label. A node is
+// selected if its name or cluster is listed, or if it has every one of the
+// Labels; an empty NodeSelector selects nothing.
+type NodeSelector struct {
+	Names    []string          `yaml:"names"`
+	Clusters []int             `yaml:"clusters"`
+	Labels   map[string]string `yaml:"labels"`
+}
+
+// Selects reports whether the given node is picked out by the selector.
+func (sel NodeSelector) Selects(nodeState NodeState) bool {
+	for _, name := range sel.Names {
+		if nodeState.Name == name {
+			return true
+		}
+	}
+	for _, cluster := range sel.Clusters {
+		if nodeState.Cluster == cluster {
+			return true
+		}
+	}
+	return len(sel.Labels) > 0 && nodeState.hasLabels(sel.Labels)
+}
+
+// A NodeFilter decides which nodes maintenance actions may target: those in
+// Scope, or every node if it's nil, except any which are Frozen.
+type NodeFilter struct {
+	Scope  *NodeSelector
+	Frozen NodeSelector
+}
+
+// Allows reports whether actions may target the given node.
+func (nf NodeFilter) Allows(nodeState NodeState) bool {
+	if nf.Scope != nil && !nf.Scope.Selects(nodeState) {
+		return false
+	}
+	return !nf.Frozen.Selects(nodeState)
+}
+
+// disallowed returns the names of the nodes in the given list which actions
+// may not target.
+func (nf NodeFilter) disallowed(state State, names []string) []string {
+	var out []string
+	for _, name := range names {
+		i := state.indexOfNode(name)
+		if i >= 0 && !nf.Allows(state.Nodes[i]) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
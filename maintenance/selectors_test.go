@@ -0,0 +1,125 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestNodeFilter_Allows(t *testing.T) {
+	t.Parallel()
+
+	nodeState := NodeState{
+		Name:    "app3-1",
+		Cluster: 3,
+		Labels:  map[string]string{"zone": "a", "rack": "r1"},
+	}
+
+	testCases := map[string]struct {
+		filter   NodeFilter
+		expected bool
+	}{
+		"no filter": {
+			expected: true,
+		},
+		"in scope by cluster": {
+			filter:   NodeFilter{Scope: &NodeSelector{Clusters: []int{3}}},
+			expected: true,
+		},
+		"out of scope by cluster": {
+			filter: NodeFilter{Scope: &NodeSelector{Clusters: []int{1, 2}}},
+		},
+		"empty scope": {
+			filter: NodeFilter{Scope: &NodeSelector{}},
+		},
+		"frozen by name": {
+			filter: NodeFilter{Frozen: NodeSelector{Names: []string{"app1-1", "app3-1"}}},
+		},
+		"frozen by labels": {
+			filter: NodeFilter{Frozen: NodeSelector{Labels: map[string]string{"zone": "a", "rack": "r1"}}},
+		},
+		"not frozen unless every label matches": {
+			filter:   NodeFilter{Frozen: NodeSelector{Labels: map[string]string{"zone": "a", "rack": "r2"}}},
+			expected: true,
+		},
+		"in scope but frozen": {
+			filter: NodeFilter{
+				Scope:  &NodeSelector{Labels: map[string]string{"zone": "a"}},
+				Frozen: NodeSelector{Clusters: []int{3}},
+			},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			if actual := tc.filter.Allows(nodeState); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestPlanner_frozenNodesStillCount(t *testing.T) {
+	t.Parallel()
+
+	// app1-2 is frozen out of the pool, so cluster 1 stays degraded and
+	// cluster 2 may never be taken down
+	startingState := State{Nodes: []NodeState{
+		{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true},
+		{Name: "app1-2", Cluster: 1, SoftwareRevision: 1, AppRunning: true, CacheWarmed: true, Healthy: true},
+		{Name: "app2-1", Cluster: 2, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, CacheWarmed: true, Healthy: true},
+	}}
+	mp := &Planner{
+		Scope:  &NodeSelector{Clusters: []int{2}},
+		Frozen: NodeSelector{Names: []string{"app1-2"}},
+	}
+	log.SetOutput(ioutil.Discard)
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+	if plan != nil {
+		t.Errorf("expected no plan, got:\n%s", maintenanceActionList(plan))
+	}
+}
+
+func ExamplePlanner_scope() {
+	log.SetFlags(0)
+	node := func(name string, cluster int) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		}
+	}
+	startingState := State{Nodes: []NodeState{
+		node("app1-1", 1),
+		node("app3-1", 3),
+		node("app3-2", 3),
+	}}
+
+	// only upgrade cluster 3, leaving app3-2 alone while it's investigated
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{
+		Scope:  &NodeSelector{Clusters: []int{3}},
+		Frozen: NodeSelector{Names: []string{"app3-2"}},
+	}
+	plan := mp.PlanActionsForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for _, action := range plan {
+		fmt.Println(action)
+	}
+	// Output:
+	// Drain node from pool: app3-1
+	// Stop app: app3-1
+	// Update software: app3-1
+	// Start app: app3-1
+	// Health check: app3-1
+	// Warm cache: app3-1
+	// Add node to pool: app3-1
+}
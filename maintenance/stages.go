@@ -0,0 +1,91 @@
+package maintenance
+
+// A Stage is a set of actions which are safe to execute concurrently.
+type Stage []MaintenanceAction
+
+// PlanStagesForTargetRevision produces the same plan as
+// PlanActionsForTargetRevision, grouped into Stages which an executor can run
+// one after another, executing every action within a stage in parallel.
+func (p *Planner) PlanStagesForTargetRevision(startingState State, targetSoftwareRevision int) []Stage {
+	plan := p.PlanActionsForTargetRevision(startingState, targetSoftwareRevision)
+	return p.StagePlan(startingState, plan, targetSoftwareRevision)
+}
+
+// StagePlan groups consecutive actions of the same kind from a plan into
+// Stages. An action only joins a stage if, for every action in the resulting
+// stage, it remains valid and satisfies all active constraints both when
+// executed before any of its peers and when executed after all of them.
+func (p *Planner) StagePlan(startingState State, plan []MaintenanceAction, targetSoftwareRevision int) []Stage {
+	return stagePlan(p.constraintsForTargetRevision(targetSoftwareRevision), startingState, plan)
+}
+
+func stagePlan(constraints []Constraint, startingState State, plan []MaintenanceAction) []Stage {
+	var stages []Stage
+	var current Stage
+	stageStart := startingState
+	for _, action := range plan {
+		if len(current) > 0 && current[0].Kind() == action.Kind() && canJoinStage(constraints, stageStart, current, action) {
+			current = append(current, action)
+			continue
+		}
+		if len(current) > 0 {
+			stages = append(stages, current)
+			stageStart = current[len(current)-1].FinalState()
+		}
+		current = Stage{action}
+	}
+	if len(current) > 0 {
+		stages = append(stages, current)
+	}
+	return stages
+}
+
+func canJoinStage(constraints []Constraint, stageStart State, stage Stage, candidate MaintenanceAction) bool {
+	members := make(Stage, 0, len(stage)+1)
+	members = append(members, stage...)
+	members = append(members, candidate)
+
+	for _, member := range stage {
+		if member.NodeName() == candidate.NodeName() {
+			return false
+		}
+	}
+
+	for i, member := range members {
+		// executed before any of its peers
+		if _, ok := rebaseAction(constraints, stageStart, member); !ok {
+			return false
+		}
+		// executed after all of its peers
+		state := stageStart
+		for j, peer := range members {
+			if j == i {
+				continue
+			}
+			rebased, ok := rebaseAction(constraints, state, peer)
+			if !ok {
+				return false
+			}
+			state = rebased.FinalState()
+		}
+		if _, ok := rebaseAction(constraints, state, member); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// rebaseAction finds the equivalent of an action when taken from a different
+// starting state, provided it's still valid and allowed by the constraints.
+func rebaseAction(constraints []Constraint, state State, action MaintenanceAction) (MaintenanceAction, bool) {
+	for _, candidate := range action.CloneForValidTargets(state) {
+		if candidate.String() != action.String() {
+			continue
+		}
+		if len(checkConstraints(constraints, state, candidate)) > 0 {
+			return nil, false
+		}
+		return candidate, true
+	}
+	return nil, false
+}
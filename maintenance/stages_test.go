@@ -0,0 +1,97 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+func ExamplePlanner_PlanStagesForTargetRevision() {
+	log.SetFlags(0)
+	startingState := State{Nodes: []NodeState{
+		NodeState{
+			Name:             "app1-1",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+		},
+		NodeState{
+			Name:             "app1-2",
+			Cluster:          1,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+		},
+		NodeState{
+			Name:             "app2-1",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       false,
+			PoolWeight:       0,
+			CacheWarmed:      false,
+		},
+		NodeState{
+			Name:             "app2-2",
+			Cluster:          2,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       0,
+			CacheWarmed:      true,
+		},
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Stop app: app2-2
+	// Stage 2:
+	//     Update software: app2-1
+	//     Update software: app2-2
+	// Stage 3:
+	//     Start app: app2-1
+	//     Start app: app2-2
+	// Stage 4:
+	//     Health check: app2-1
+	//     Health check: app2-2
+	// Stage 5:
+	//     Warm cache: app2-1
+	//     Warm cache: app2-2
+	// Stage 6:
+	//     Add node to pool: app2-1
+	//     Add node to pool: app2-2
+	// Stage 7:
+	//     Drain node from pool: app1-1
+	//     Drain node from pool: app1-2
+	// Stage 8:
+	//     Stop app: app1-1
+	//     Stop app: app1-2
+	// Stage 9:
+	//     Update software: app1-1
+	//     Update software: app1-2
+	// Stage 10:
+	//     Start app: app1-1
+	//     Start app: app1-2
+	// Stage 11:
+	//     Health check: app1-1
+	//     Health check: app1-2
+	// Stage 12:
+	//     Warm cache: app1-1
+	//     Warm cache: app1-2
+	// Stage 13:
+	//     Add node to pool: app1-1
+	//     Add node to pool: app1-2
+}
@@ -0,0 +1,27 @@
+package maintenance
+
+// A Strategy plans how to bring a fleet to a target revision. Planner is the
+// rolling strategy; BlueGreenPlanner is an alternative for fleets which can
+// afford a whole standby group.
+type Strategy interface {
+	PlanActionsForTargetRevision(startingState State, targetSoftwareRevision int) []MaintenanceAction
+	PlanStagesForTargetRevision(startingState State, targetSoftwareRevision int) []Stage
+	PlanScheduleForTargetRevision(startingState State, targetSoftwareRevision int) Schedule
+	// Expansions returns how many states were expanded searching for plans.
+	Expansions() int
+}
+
+var (
+	_ Strategy = (*Planner)(nil)
+	_ Strategy = (*BlueGreenPlanner)(nil)
+)
+
+// PlanCost totals the cost of a plan under the given cost model, so that
+// plans from different strategies can be compared.
+func PlanCost(costs CostModel, plan []MaintenanceAction) float64 {
+	var cost float64
+	for _, action := range plan {
+		cost += costs.Cost(action)
+	}
+	return cost
+}
@@ -0,0 +1,302 @@
+package maintenance
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	KindProvisionNode    ActionKind = "provisionnode"
+	KindDecommissionNode ActionKind = "decommissionnode"
+)
+
+// A SurgePolicy lets the planner add temporary nodes, already running the
+// target revision, before it drains old ones, as Kubernetes' maxSurge does.
+// Extra nodes are decommissioned before the rollout ends, so that each
+// cluster ends up with as many nodes of each role as it started with. Nodes
+// taken out of the pool to make way for new ones needn't be upgraded at all.
+type SurgePolicy struct {
+	// MaxSurge is how many nodes the fleet may have, at any one time, beyond
+	// its starting size.
+	MaxSurge int `yaml:"maxsurge"`
+}
+
+// Validate rejects a negative surge budget.
+func (sp *SurgePolicy) Validate() error {
+	if sp != nil && sp.MaxSurge < 0 {
+		return fmt.Errorf("negative MaxSurge %d", sp.MaxSurge)
+	}
+	return nil
+}
+
+// joining reports whether a node was provisioned by the rollout and hasn't
+// yet joined the pool. It wasn't serving anything before, so it isn't down.
+func (ns NodeState) joining() bool {
+	return ns.Provisioned && !ns.inPool()
+}
+
+// sizeKey identifies the nodes whose number the fleet must keep: those of
+// one role in one cluster.
+type sizeKey struct {
+	Cluster int
+	Role    string
+}
+
+// fleetSize counts nodes by cluster and role, not counting failed nodes
+// which have already been replaced.
+type fleetSize map[sizeKey]int
+
+func fleetSizeOf(state State) fleetSize {
+	size := make(fleetSize)
+	for _, nodeState := range state.Nodes {
+		if !nodeState.retired() {
+			size[sizeKey{nodeState.Cluster, nodeState.roleOrDefault()}] += 1
+		}
+	}
+	return size
+}
+
+// excess returns how many nodes the fleet of the given size has beyond this
+// one, in total.
+func (fs fleetSize) excess(size fleetSize) int {
+	var excess int
+	for key, n := range size {
+		if n > fs[key] {
+			excess += n - fs[key]
+		}
+	}
+	return excess
+}
+
+// surgeName names the n'th node provisioned in a cluster.
+func surgeName(cluster, n int) string {
+	return fmt.Sprintf("surge-%d-%d", cluster, n)
+}
+
+// ProvisionNodeAction adds a node running the target revision, OS patch level
+// and configuration to a cluster, so long as the fleet stays within MaxSurge
+// nodes of the size it started at. The new node is a copy of the first of the
+// cluster's nodes with the same role, and goes through the rest of its
+// pipeline, from the health check onwards, before it joins the pool; it
+// needn't keep in step with the rest of its group. Nodes aren't provisioned
+// ahead of unmet Dependencies, nor before the Canary nodes have been verified.
+type ProvisionNodeAction struct {
+	Goal
+	MaxSurge     int
+	Dependencies Dependencies
+	Canary       *CanaryPolicy
+	Filter       NodeFilter
+	desired      fleetSize
+	nodeName     string
+	finalState   State
+}
+
+func (pna *ProvisionNodeAction) String() string {
+	return fmt.Sprintf("Provision node: %s", pna.nodeName)
+}
+
+func (pna *ProvisionNodeAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	if pna.desired.excess(fleetSizeOf(startingState)) >= pna.MaxSurge {
+		return nil
+	}
+	if pna.Canary != nil && !startingState.Canary.verified(pna.Goal) {
+		return nil
+	}
+
+	var out []MaintenanceAction
+	seen := make(map[sizeKey]bool)
+	for _, template := range startingState.Nodes {
+		key := sizeKey{template.Cluster, template.roleOrDefault()}
+		if seen[key] || template.failed() || !pna.Filter.Allows(template) {
+			continue
+		}
+		seen[key] = true
+		if pna.Dependencies.unmetFor(startingState, template, pna.TargetRevision) != nil {
+			continue
+		}
+
+		newNodeState := surgeNodeFor(startingState, template, pna.Goal)
+
+		newState := startingState
+		newState.Nodes = append(append([]NodeState(nil), startingState.Nodes...), newNodeState)
+		out = append(out, &ProvisionNodeAction{
+			Goal:         pna.Goal,
+			MaxSurge:     pna.MaxSurge,
+			Dependencies: pna.Dependencies,
+			Canary:       pna.Canary,
+			Filter:       pna.Filter,
+			desired:      pna.desired,
+			nodeName:     newNodeState.Name,
+			finalState:   newState,
+		})
+	}
+	return out
+}
+
+// surgeNodeFor returns the node provisioned next in the given template's
+// cluster: a copy of it, already running the goal's revision, OS patch level
+// and configuration.
+func surgeNodeFor(state State, template NodeState, goal Goal) NodeState {
+	n := 1
+	for state.indexOfNode(surgeName(template.Cluster, n)) >= 0 {
+		n++
+	}
+	return NodeState{
+		Name:             surgeName(template.Cluster, n),
+		Cluster:          template.Cluster,
+		SoftwareRevision: goal.TargetRevision,
+		OSPatchLevel:     newOSPatchLevel(template, goal.OSPatchLevel),
+		ConfigVersion:    newConfigVersion(template, goal.Config),
+		AppRunning:       true,
+		Role:             template.Role,
+		Capacity:         template.Capacity,
+		Labels:           template.Labels,
+		Provisioned:      true,
+	}
+}
+
+func (pna *ProvisionNodeAction) FinalState() State {
+	return pna.finalState
+}
+
+func (pna *ProvisionNodeAction) NodeName() string {
+	return pna.nodeName
+}
+
+func (pna *ProvisionNodeAction) Kind() ActionKind {
+	return KindProvisionNode
+}
+
+// DecommissionNodeAction removes a node which is out of the pool from the
+// fleet, so long as its cluster has more nodes of its role than it started
+// with, and it isn't still waiting for its connections to close.
+type DecommissionNodeAction struct {
+	Filter     NodeFilter
+	desired    fleetSize
+	nodeName   string
+	finalState State
+}
+
+func (dna *DecommissionNodeAction) String() string {
+	return fmt.Sprintf("Decommission node: %s", dna.nodeName)
+}
+
+func (dna *DecommissionNodeAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	size := fleetSizeOf(startingState)
+
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !dna.Filter.Allows(nodeState) || nodeState.inPool() || nodeState.failed() {
+			continue
+		}
+		if nodeState.AppRunning && nodeState.awaitsConnections() {
+			continue
+		}
+		key := sizeKey{nodeState.Cluster, nodeState.roleOrDefault()}
+		if size[key] <= dna.desired[key] {
+			continue
+		}
+
+		newState := startingState
+		newState.Nodes = make([]NodeState, 0, len(startingState.Nodes)-1)
+		newState.Nodes = append(newState.Nodes, startingState.Nodes[:i]...)
+		newState.Nodes = append(newState.Nodes, startingState.Nodes[i+1:]...)
+		out = append(out, &DecommissionNodeAction{
+			Filter:     dna.Filter,
+			desired:    dna.desired,
+			nodeName:   nodeState.Name,
+			finalState: newState,
+		})
+	}
+	return out
+}
+
+func (dna *DecommissionNodeAction) FinalState() State {
+	return dna.finalState
+}
+
+func (dna *DecommissionNodeAction) NodeName() string {
+	return dna.nodeName
+}
+
+func (dna *DecommissionNodeAction) Kind() ActionKind {
+	return KindDecommissionNode
+}
+
+// estimateSurge is estimateAction's counterpart for plans which may surge.
+// Rather than see its pipeline through and be ramped up to FullWeight, any
+// node may be drained, if need be, and decommissioned. Each cluster must
+// still end up with as many nodes of each role as it started with, though,
+// so every node decommissioned beyond those it has in excess must be made
+// up for by provisioning another, which must see its own pipeline through.
+// Each cluster's nodes of each role are charged for the cheapest mix of the
+// two which leaves it the right size.
+func estimateSurge(state State, goal Goal, desired fleetSize, rampStep int, costs CostModel, filter NodeFilter) float64 {
+	var cost float64
+	// the cost of keeping each node, over that of decommissioning it
+	keepCosts := make(map[sizeKey][]float64)
+	templates := make(map[sizeKey]NodeState)
+	// how many nodes the rollout may keep, and how many of those places
+	// might be taken by replacements for failed nodes
+	places := make(map[sizeKey]int)
+	replacements := make(map[sizeKey]int)
+	for key, n := range desired {
+		places[key] = n
+	}
+	for _, nodeState := range state.Nodes {
+		key := sizeKey{nodeState.Cluster, nodeState.roleOrDefault()}
+		if nodeState.retired() {
+			continue
+		}
+		if !filter.Allows(nodeState) {
+			places[key]--
+			continue
+		}
+		if nodeState.failed() {
+			cost += estimateReplacement(nodeState, costs)
+			replacements[key]++
+			continue
+		}
+		if _, found := templates[key]; !found {
+			templates[key] = nodeState
+		}
+
+		keep := baseEstimateForNode(nodeState, goal, costs) + estimateRampForNode(nodeState, goal, rampStep, costs)
+		decommission := costs.costForKind(KindDecommissionNode)
+		if nodeState.inPool() {
+			decommission += costs.costForNode(nodeState, KindDrainNodeFromPool)
+		}
+		if nodeState.AppRunning && nodeState.awaitsConnections() {
+			decommission += costs.costForNode(nodeState, KindWaitForDrain)
+		}
+		cost += decommission
+		keepCosts[key] = append(keepCosts[key], keep-decommission)
+	}
+
+	for key, template := range templates {
+		newNodeState := surgeNodeFor(state, template, goal)
+		provision := costs.costForNode(newNodeState, KindProvisionNode) + baseEstimateForNode(newNodeState, goal, costs) + estimateRampForNode(newNodeState, goal, rampStep, costs)
+
+		// keep the nodes cheapest to keep, for so long as that's cheaper
+		// than decommissioning them and provisioning others in their place
+		sort.Float64s(keepCosts[key])
+		var kept int
+		for _, keepCost := range keepCosts[key] {
+			if kept >= places[key] {
+				break
+			}
+			if kept < places[key]-replacements[key] {
+				keepCost -= provision
+			}
+			if keepCost >= 0 {
+				break
+			}
+			cost += keepCost
+			kept++
+		}
+		if missing := places[key] - replacements[key] - kept; missing > 0 {
+			cost += float64(missing) * provision
+		}
+	}
+	return cost
+}
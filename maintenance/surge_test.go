@@ -0,0 +1,219 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestProvisionNodeAction_CloneForValidTargets(t *testing.T) {
+	t.Parallel()
+
+	old := NodeState{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight, Capacity: 100}
+	surge := NodeState{Name: "surge-1-1", Cluster: 1, SoftwareRevision: 2, AppRunning: true, Capacity: 100, Provisioned: true}
+	desired := fleetSize{{Cluster: 1, Role: DefaultRole}: 1}
+
+	testCases := map[string]struct {
+		state    State
+		canary   *CanaryPolicy
+		frozen   NodeSelector
+		expected []string
+	}{
+		"within budget": {
+			state:    State{Nodes: []NodeState{old}},
+			expected: []string{"Provision node: surge-1-1"},
+		},
+		"budget used": {
+			state: State{Nodes: []NodeState{old, surge}},
+		},
+		"budget freed by decommissioning": {
+			state:    State{Nodes: []NodeState{surge}},
+			expected: []string{"Provision node: surge-1-2"},
+		},
+		"canaries unverified": {
+			state:  State{Nodes: []NodeState{old}},
+			canary: &CanaryPolicy{},
+		},
+		"cluster frozen": {
+			state:  State{Nodes: []NodeState{old}},
+			frozen: NodeSelector{Clusters: []int{1}},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			prototype := &ProvisionNodeAction{
+				Goal:     Goal{TargetRevision: 2},
+				MaxSurge: 1,
+				Canary:   tc.canary,
+				Filter:   NodeFilter{Frozen: tc.frozen},
+				desired:  desired,
+			}
+			var actual []string
+			for _, action := range prototype.CloneForValidTargets(tc.state) {
+				actual = append(actual, action.String())
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDecommissionNodeAction_CloneForValidTargets(t *testing.T) {
+	t.Parallel()
+
+	state := State{Nodes: []NodeState{
+		{Name: "app1-1", Cluster: 1, SoftwareRevision: 1, AppRunning: true},
+		{Name: "app1-2", Cluster: 1, SoftwareRevision: 1, AppRunning: true, PoolWeight: FullWeight},
+		{Name: "app2-1", Cluster: 2, SoftwareRevision: 1, AppRunning: true},
+		{Name: "surge-1-1", Cluster: 1, SoftwareRevision: 2, AppRunning: true, PoolWeight: FullWeight, Provisioned: true},
+	}}
+	prototype := &DecommissionNodeAction{desired: fleetSize{
+		{Cluster: 1, Role: DefaultRole}: 2,
+		{Cluster: 2, Role: DefaultRole}: 1,
+	}}
+
+	// only cluster 1 has a node to spare, and only app1-1 is out of the pool
+	actions := prototype.CloneForValidTargets(state)
+	if len(actions) != 1 {
+		t.Fatalf("expected 1 action, got %d", len(actions))
+	}
+	if actions[0].NodeName() != "app1-1" {
+		t.Errorf("expected to decommission app1-1, got %s", actions[0].NodeName())
+	}
+	if n := len(actions[0].FinalState().Nodes); n != 3 {
+		t.Errorf("expected 3 nodes afterwards, got %d", n)
+	}
+}
+
+func TestPlanner_surgeEstimate(t *testing.T) {
+	node := func(name string, cluster int) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		}
+	}
+
+	testCases := map[string]struct {
+		startingState State
+		planner       Planner
+	}{
+		"cheaper to replace": {
+			startingState: State{Nodes: []NodeState{node("app1-1", 1), node("app1-2", 1)}},
+			planner:       Planner{Surge: &SurgePolicy{MaxSurge: 1}},
+		},
+		"cheaper to upgrade": {
+			startingState: State{Nodes: []NodeState{node("app1-1", 1), node("app1-2", 1)}},
+			planner: Planner{
+				Surge: &SurgePolicy{MaxSurge: 1},
+				Costs: CostModel{ByKind: map[ActionKind]float64{KindProvisionNode: 5}},
+			},
+		},
+		"ramping up": {
+			startingState: State{Nodes: []NodeState{node("app1-1", 1), node("app2-1", 2)}},
+			planner:       Planner{Surge: &SurgePolicy{MaxSurge: 1}, RampStep: 50},
+		},
+		"waiting for connections": {
+			startingState: State{Nodes: []NodeState{node("app1-1", 1), node("app1-2", 1)}},
+			planner:       Planner{Surge: &SurgePolicy{MaxSurge: 1}, Drain: DrainPolicy{Timeout: time.Minute}},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			log.SetOutput(ioutil.Discard)
+			astar := tc.planner
+			astarPlan := astar.PlanActionsForTargetRevision(tc.startingState, 2)
+			dijkstra := tc.planner
+			dijkstra.Algorithm = AlgorithmDijkstra
+			dijkstraPlan := dijkstra.PlanActionsForTargetRevision(tc.startingState, 2)
+			log.SetOutput(os.Stdout)
+
+			// an admissible estimate finds plans as cheap as Dijkstra's
+			astarCost := PlanCost(tc.planner.Costs, astarPlan)
+			dijkstraCost := PlanCost(tc.planner.Costs, dijkstraPlan)
+			if len(astarPlan) == 0 || astarCost != dijkstraCost {
+				t.Errorf("expected A* to find a plan costing %v, got %v:\n%s", dijkstraCost, astarCost, maintenanceActionList(astarPlan))
+			}
+		})
+	}
+}
+
+func ExamplePlanner_surge() {
+	log.SetFlags(0)
+	// neither node can be spared until another joins the pool
+	startingState := State{
+		Nodes: []NodeState{
+			NodeState{
+				Name:             "app1-1",
+				Cluster:          1,
+				SoftwareRevision: 1,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
+				Healthy:          true,
+				Capacity:         100,
+			},
+			NodeState{
+				Name:             "app1-2",
+				Cluster:          1,
+				SoftwareRevision: 1,
+				AppRunning:       true,
+				PoolWeight:       FullWeight,
+				CacheWarmed:      true,
+				Healthy:          true,
+				Capacity:         100,
+			},
+		},
+		Demand: Demand{Global: 200},
+	}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{Surge: &SurgePolicy{MaxSurge: 1}}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Provision node: surge-1-1
+	// Stage 2:
+	//     Health check: surge-1-1
+	// Stage 3:
+	//     Warm cache: surge-1-1
+	// Stage 4:
+	//     Add node to pool: surge-1-1
+	// Stage 5:
+	//     Drain node from pool: app1-1
+	// Stage 6:
+	//     Decommission node: app1-1
+	// Stage 7:
+	//     Provision node: surge-1-2
+	// Stage 8:
+	//     Health check: surge-1-2
+	// Stage 9:
+	//     Warm cache: surge-1-2
+	// Stage 10:
+	//     Add node to pool: surge-1-2
+	// Stage 11:
+	//     Drain node from pool: app1-2
+	// Stage 12:
+	//     Decommission node: app1-2
+}
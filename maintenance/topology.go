@@ -0,0 +1,122 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+// RegionLabel is the label under which a node's region is recorded when a
+// State is built from a Topology.
+const RegionLabel = "region"
+
+// A Topology describes a fleet hierarchically: regions contain clusters,
+// which contain nodes. It's an alternative to listing every node in a State,
+// and carries the policy for rolling out across the hierarchy.
+type Topology struct {
+	Policy  TopologyPolicy   `yaml:"policy"`
+	Regions []RegionTopology `yaml:"regions"`
+	Schema  SchemaState      `yaml:"schema"`
+	// Demand is served by the fleet as a whole, in requests per second.
+	Demand float64 `yaml:"demand"`
+}
+
+type RegionTopology struct {
+	Name     string            `yaml:"name"`
+	Clusters []ClusterTopology `yaml:"clusters"`
+}
+
+type ClusterTopology struct {
+	Cluster int         `yaml:"cluster"`
+	Nodes   []NodeState `yaml:"nodes"`
+	// Demand is served by this cluster's own nodes, in requests per second.
+	Demand float64 `yaml:"demand"`
+}
+
+// State flattens the Topology into a State, recording each node's cluster
+// and region. Cluster numbers must be unique across all regions.
+func (t Topology) State() (State, error) {
+	state := State{Schema: t.Schema, Demand: Demand{Global: t.Demand}}
+	clusterRegions := make(map[int]string)
+	for _, region := range t.Regions {
+		for _, cluster := range region.Clusters {
+			if otherRegion, found := clusterRegions[cluster.Cluster]; found {
+				return State{}, fmt.Errorf("cluster %d appears in both region %q and region %q", cluster.Cluster, otherRegion, region.Name)
+			}
+			clusterRegions[cluster.Cluster] = region.Name
+			if cluster.Demand != 0 {
+				if state.Demand.ByCluster == nil {
+					state.Demand.ByCluster = make(map[int]float64)
+				}
+				state.Demand.ByCluster[cluster.Cluster] = cluster.Demand
+			}
+
+			for _, nodeState := range cluster.Nodes {
+				nodeState.Cluster = cluster.Cluster
+				labels := make(map[string]string, len(nodeState.Labels)+1)
+				for key, value := range nodeState.Labels {
+					labels[key] = value
+				}
+				labels[RegionLabel] = region.Name
+				nodeState.Labels = labels
+				state.Nodes = append(state.Nodes, nodeState)
+			}
+		}
+	}
+	return state, nil
+}
+
+// TopologyPolicy limits how much of the fleet may be under maintenance at
+// each level of a Topology, and in what order regions are rolled out.
+type TopologyPolicy struct {
+	// RegionOrder lists regions in the order they should be rolled out;
+	// unlisted regions follow, sorted by name.
+	RegionOrder []string `yaml:"regionorder"`
+	// MaxRegionsDown limits how many regions may have nodes down at once;
+	// defaults to 1.
+	MaxRegionsDown int `yaml:"maxregionsdown"`
+	// MaxClustersDownPerRegion limits how many clusters within a region may
+	// have nodes down at once; defaults to 1.
+	MaxClustersDownPerRegion int `yaml:"maxclustersdownperregion"`
+	// MaxNodesDownPerCluster limits how many nodes within a cluster may be
+	// down at once; 0 means no limit.
+	MaxNodesDownPerCluster int `yaml:"maxnodesdownpercluster"`
+}
+
+func (tp TopologyPolicy) constraints(goal Goal) []Constraint {
+	maxRegionsDown := tp.MaxRegionsDown
+	if maxRegionsDown == 0 {
+		maxRegionsDown = 1
+	}
+	maxClustersDownPerRegion := tp.MaxClustersDownPerRegion
+	if maxClustersDownPerRegion == 0 {
+		maxClustersDownPerRegion = 1
+	}
+
+	constraints := []Constraint{
+		&RolloutOrderConstraint{
+			Goal:  goal,
+			Label: RegionLabel,
+			Order: tp.RegionOrder,
+		},
+		&RolloutOrderConstraint{
+			Goal:   goal,
+			Label:  ClusterLabel,
+			Within: RegionLabel,
+		},
+		&MaxDegradedGroupsConstraint{
+			Label: RegionLabel,
+			Max:   maxRegionsDown,
+		},
+		&MaxDegradedGroupsConstraint{
+			Label:  ClusterLabel,
+			Within: RegionLabel,
+			Max:    maxClustersDownPerRegion,
+		},
+	}
+	if tp.MaxNodesDownPerCluster > 0 {
+		constraints = append(constraints, &MaxDownPerGroupConstraint{
+			Label: ClusterLabel,
+			Max:   tp.MaxNodesDownPerCluster,
+		})
+	}
+	return constraints
+}
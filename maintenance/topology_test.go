@@ -0,0 +1,136 @@
+package maintenance
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func testTopology(policy TopologyPolicy) Topology {
+	node := func(name string) NodeState {
+		return NodeState{
+			Name:             name,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		}
+	}
+	return Topology{
+		Policy: policy,
+		Regions: []RegionTopology{
+			{
+				Name: "us",
+				Clusters: []ClusterTopology{
+					{Cluster: 2, Nodes: []NodeState{node("us2-1")}},
+					{Cluster: 1, Nodes: []NodeState{node("us1-1"), node("us1-2")}},
+				},
+			},
+			{
+				Name: "eu",
+				Clusters: []ClusterTopology{
+					{Cluster: 3, Nodes: []NodeState{node("eu3-1"), node("eu3-2")}},
+				},
+			},
+		},
+	}
+}
+
+func TestTopology_State(t *testing.T) {
+	t.Parallel()
+
+	state, err := testTopology(TopologyPolicy{}).State()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	i := state.indexOfNode("eu3-2")
+	if i < 0 {
+		t.Fatal("node eu3-2 missing from state")
+	}
+	if state.Nodes[i].Cluster != 3 || state.Nodes[i].Label(RegionLabel) != "eu" {
+		t.Errorf("expected eu3-2 in cluster 3 of region eu, got cluster %d of region %q", state.Nodes[i].Cluster, state.Nodes[i].Label(RegionLabel))
+	}
+
+	duplicated := testTopology(TopologyPolicy{})
+	duplicated.Regions[1].Clusters[0].Cluster = 1
+	_, err = duplicated.State()
+	if err == nil {
+		t.Error("expected an error for a cluster in two regions, got nil")
+	}
+}
+
+func TestPlanner_topologyPolicy(t *testing.T) {
+	testCases := map[string]struct {
+		policy        TopologyPolicy
+		expectedOrder []string
+	}{
+		"regions in configured order, clusters in numeric order": {
+			policy: TopologyPolicy{
+				RegionOrder: []string{"eu", "us"},
+			},
+			expectedOrder: []string{"3", "1", "2"},
+		},
+		"one node down per cluster": {
+			policy: TopologyPolicy{
+				RegionOrder:            []string{"us", "eu"},
+				MaxNodesDownPerCluster: 1,
+			},
+			expectedOrder: []string{"1", "2", "3"},
+		},
+	}
+
+	for testName, tc := range testCases {
+		t.Run(testName, func(t *testing.T) {
+			topology := testTopology(tc.policy)
+			startingState, err := topology.State()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			mp := &Planner{Topology: &topology.Policy}
+			log.SetOutput(ioutil.Discard)
+			plan := mp.PlanActionsForTargetRevision(startingState, 2)
+			log.SetOutput(os.Stdout)
+			if plan == nil {
+				t.Fatal("expected a plan, got nil")
+			}
+
+			var clusterOrder []string
+			for _, action := range plan {
+				finalState := action.FinalState()
+				cluster := finalState.Nodes[finalState.indexOfNode(action.NodeName())].Label(ClusterLabel)
+				if len(clusterOrder) == 0 || clusterOrder[len(clusterOrder)-1] != cluster {
+					clusterOrder = append(clusterOrder, cluster)
+				}
+
+				downRegions := make(map[string]bool)
+				for _, nodeState := range finalState.Nodes {
+					if !nodeState.inPool() {
+						downRegions[nodeState.Label(RegionLabel)] = true
+					}
+				}
+				if len(downRegions) > 1 {
+					t.Errorf("after %q, %d regions are down", action, len(downRegions))
+				}
+				if tc.policy.MaxNodesDownPerCluster > 0 {
+					for cluster, count := range downNodesByLabel(finalState, ClusterLabel) {
+						if count > tc.policy.MaxNodesDownPerCluster {
+							t.Errorf("after %q, %d nodes are down in cluster %s", action, count, cluster)
+						}
+					}
+				}
+			}
+
+			if len(clusterOrder) != len(tc.expectedOrder) {
+				t.Fatalf("expected clusters to be visited in order %v, got %v", tc.expectedOrder, clusterOrder)
+			}
+			for i := range clusterOrder {
+				if clusterOrder[i] != tc.expectedOrder[i] {
+					t.Fatalf("expected clusters to be visited in order %v, got %v", tc.expectedOrder, clusterOrder)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package maintenance
+
+import (
+	"fmt"
+)
+
+const KindRampWeight ActionKind = "rampweight"
+
+// FullWeight is the load-balancer weight of a node taking its full share of
+// traffic.
+const FullWeight = 100
+
+// inPool reports whether the node is in the load-balancer pool, at any
+// weight.
+func (ns NodeState) inPool() bool {
+	return ns.PoolWeight > 0
+}
+
+// weightedCapacity returns the share of the node's Capacity its weight in the
+// pool puts to use.
+func (ns NodeState) weightedCapacity() float64 {
+	return ns.Capacity * float64(ns.PoolWeight) / FullWeight
+}
+
+// nodeStateYAML reads the InLoadbalancerPool flag written before nodes had a
+// PoolWeight.
+type nodeStateYAML struct {
+	PoolWeight         *int  `yaml:"poolweight"`
+	InLoadbalancerPool *bool `yaml:"inloadbalancerpool"`
+}
+
+// UnmarshalYAML reads a NodeState, giving nodes which only say they're in
+// the pool FullWeight.
+func (ns *NodeState) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain NodeState
+	err := unmarshal((*plain)(ns))
+	if err != nil {
+		return err
+	}
+	var legacy nodeStateYAML
+	err = unmarshal(&legacy)
+	if err != nil {
+		return err
+	}
+	if legacy.PoolWeight == nil && legacy.InLoadbalancerPool != nil && *legacy.InLoadbalancerPool {
+		ns.PoolWeight = FullWeight
+	}
+	return nil
+}
+
+// validateRampStep rejects weight increments outside (0, FullWeight]; zero
+// means nodes rejoin the pool at FullWeight.
+func validateRampStep(step int) error {
+	if step < 0 || step > FullWeight {
+		return fmt.Errorf("RampStep %d isn't between 0 and %d", step, FullWeight)
+	}
+	return nil
+}
+
+func rampStepOrDefault(step int) int {
+	if step == 0 {
+		return FullWeight
+	}
+	return step
+}
+
+// RampWeightAction raises the weight of a node which has rejoined the pool by
+// RampStep, up to FullWeight.
+type RampWeightAction struct {
+	Goal
+	RampStep   int
+	Filter     NodeFilter
+	nodeName   string
+	finalState State
+}
+
+func (rwa *RampWeightAction) String() string {
+	return fmt.Sprintf("Ramp weight: %s", rwa.nodeName)
+}
+
+func (rwa *RampWeightAction) CloneForValidTargets(startingState State) []MaintenanceAction {
+	var out []MaintenanceAction
+	for i, nodeState := range startingState.Nodes {
+		if !rwa.Filter.Allows(nodeState) || !nodeState.inPool() {
+			continue
+		}
+		if nextKindForNode(nodeState, rwa.Goal) != KindAddNodeToPool {
+			continue
+		}
+		newNodeState := nodeState
+		newNodeState.PoolWeight += rampStepOrDefault(rwa.RampStep)
+		if newNodeState.PoolWeight > FullWeight {
+			newNodeState.PoolWeight = FullWeight
+		}
+
+		out = append(out, &RampWeightAction{
+			Goal:       rwa.Goal,
+			RampStep:   rwa.RampStep,
+			Filter:     rwa.Filter,
+			nodeName:   nodeState.Name,
+			finalState: startingState.withNode(i, newNodeState),
+		})
+	}
+	return out
+}
+
+func (rwa *RampWeightAction) FinalState() State {
+	return rwa.finalState
+}
+
+func (rwa *RampWeightAction) NodeName() string {
+	return rwa.nodeName
+}
+
+func (rwa *RampWeightAction) Kind() ActionKind {
+	return KindRampWeight
+}
+
+// estimateRamp returns the cost of ramping every node the filter allows up
+// to FullWeight.
+func estimateRamp(state State, goal Goal, rampStep int, costs CostModel, filter NodeFilter) float64 {
+	var cost float64
+	for _, nodeState := range state.Nodes {
+		if !filter.Allows(nodeState) || nodeState.failed() {
+			continue
+		}
+		cost += estimateRampForNode(nodeState, goal, rampStep, costs)
+	}
+	return cost
+}
+
+// estimateRampForNode returns the cost of ramping a node up to FullWeight,
+// counting a node which is out of the pool, or must leave it for
+// maintenance, from the first step of the ramp.
+func estimateRampForNode(nodeState NodeState, goal Goal, rampStep int, costs CostModel) float64 {
+	step := rampStepOrDefault(rampStep)
+	var cost float64
+	weight := nodeState.PoolWeight
+	if weight == 0 || !nodeState.upToDate(goal) {
+		weight = step
+	}
+	for ; weight < FullWeight; weight += step {
+		cost += costs.costForNode(nodeState, KindRampWeight)
+	}
+	return cost
+}
@@ -0,0 +1,174 @@
+package maintenance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestNodeState_UnmarshalYAML(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		in       string
+		expected int
+	}{
+		"weight": {
+			in:       "{name: app1-1, poolweight: 50}",
+			expected: 50,
+		},
+		"legacy in pool": {
+			in:       "{name: app1-1, inloadbalancerpool: true}",
+			expected: FullWeight,
+		},
+		"legacy out of pool": {
+			in:       "{name: app1-1, inloadbalancerpool: false}",
+			expected: 0,
+		},
+		"weight wins over legacy flag": {
+			in:       "{name: app1-1, poolweight: 25, inloadbalancerpool: true}",
+			expected: 25,
+		},
+		"neither": {
+			in:       "{name: app1-1}",
+			expected: 0,
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			var nodeState NodeState
+			err := yaml.Unmarshal([]byte(tc.in), &nodeState)
+			if err != nil {
+				t.Fatalf("yaml.Unmarshal: %s", err)
+			}
+			if nodeState.Name != "app1-1" {
+				t.Errorf("expected name %q, got %q", "app1-1", nodeState.Name)
+			}
+			if nodeState.PoolWeight != tc.expected {
+				t.Errorf("expected weight %d, got %d", tc.expected, nodeState.PoolWeight)
+			}
+		})
+	}
+}
+
+func TestRampWeightAction_CloneForValidTargets(t *testing.T) {
+	t.Parallel()
+
+	testCases := map[string]struct {
+		weight   int
+		rampStep int
+		expected []int
+	}{
+		"out of pool": {
+			weight:   0,
+			rampStep: 30,
+		},
+		"first step": {
+			weight:   30,
+			rampStep: 30,
+			expected: []int{60},
+		},
+		"capped at full weight": {
+			weight:   90,
+			rampStep: 30,
+			expected: []int{FullWeight},
+		},
+		"already at full weight": {
+			weight:   FullWeight,
+			rampStep: 30,
+		},
+		"no ramp": {
+			weight:   50,
+			expected: []int{FullWeight},
+		},
+	}
+
+	for testName, tc := range testCases {
+		tc := tc
+		t.Run(testName, func(t *testing.T) {
+			t.Parallel()
+			state := State{Nodes: []NodeState{
+				{Name: "app1-1", Cluster: 1, SoftwareRevision: 2, AppRunning: true, PoolWeight: tc.weight, CacheWarmed: true, Healthy: true},
+			}}
+			prototype := &RampWeightAction{Goal: Goal{TargetRevision: 2}, RampStep: tc.rampStep}
+			var actual []int
+			for _, action := range prototype.CloneForValidTargets(state) {
+				actual = append(actual, action.FinalState().Nodes[0].PoolWeight)
+			}
+			if fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func ExamplePlanner_rampStep() {
+	log.SetFlags(0)
+	node := func(name string, cluster int) NodeState {
+		return NodeState{
+			Name:             name,
+			Cluster:          cluster,
+			SoftwareRevision: 1,
+			AppRunning:       true,
+			PoolWeight:       FullWeight,
+			CacheWarmed:      true,
+			Healthy:          true,
+		}
+	}
+	startingState := State{Nodes: []NodeState{
+		node("app1-1", 1),
+		node("app2-1", 2),
+	}}
+
+	log.SetOutput(ioutil.Discard)
+	mp := &Planner{RampStep: 50}
+	stages := mp.PlanStagesForTargetRevision(startingState, 2)
+	log.SetOutput(os.Stdout)
+
+	for i, stage := range stages {
+		fmt.Printf("Stage %d:\n", i+1)
+		for _, action := range stage {
+			fmt.Printf("    %s\n", action)
+		}
+	}
+	// Output:
+	// Stage 1:
+	//     Drain node from pool: app1-1
+	// Stage 2:
+	//     Stop app: app1-1
+	// Stage 3:
+	//     Update software: app1-1
+	// Stage 4:
+	//     Start app: app1-1
+	// Stage 5:
+	//     Health check: app1-1
+	// Stage 6:
+	//     Warm cache: app1-1
+	// Stage 7:
+	//     Add node to pool: app1-1
+	// Stage 8:
+	//     Ramp weight: app1-1
+	// Stage 9:
+	//     Drain node from pool: app2-1
+	// Stage 10:
+	//     Stop app: app2-1
+	// Stage 11:
+	//     Update software: app2-1
+	// Stage 12:
+	//     Start app: app2-1
+	// Stage 13:
+	//     Health check: app2-1
+	// Stage 14:
+	//     Warm cache: app2-1
+	// Stage 15:
+	//     Add node to pool: app2-1
+	// Stage 16:
+	//     Ramp weight: app2-1
+}
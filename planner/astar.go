@@ -9,7 +9,30 @@ type NodeIsGoaler func(n interface{}) bool
 type NodeEstimator func(dst interface{}) float64
 type NeighborGenerator func(n interface{}) []interface{}
 
+// A NodeKeyer maps nodes which represent the same point in the search space
+// to the same (comparable) key, so that only the cheapest path to that point
+// is pursued. A nil NodeKeyer treats every node as distinct.
+type NodeKeyer func(n interface{}) interface{}
+
+// A NodeRanker ranks a node reached from src, to break ties between nodes
+// which are equally promising; lower ranks are expanded first. Ranks never
+// change the cost of the path found, only which of several equally cheap
+// paths it is. A nil NodeRanker ranks every node alike, expanding them in
+// the order they were found.
+type NodeRanker func(src, dst interface{}) int
+
+// Options refine a search; the zero value searches every path, breaking ties
+// in the order nodes were found.
+type Options struct {
+	Keyer  NodeKeyer
+	Ranker NodeRanker
+}
+
 func AStarFindPath(start interface{}, coster NodeCoster, estimator NodeEstimator, isGoaler NodeIsGoaler, nGen NeighborGenerator) (map[interface{}]interface{}, map[interface{}]float64, interface{}) {
+	return AStarFindPathWithOptions(start, coster, estimator, isGoaler, nGen, Options{})
+}
+
+func AStarFindPathWithOptions(start interface{}, coster NodeCoster, estimator NodeEstimator, isGoaler NodeIsGoaler, nGen NeighborGenerator, options Options) (map[interface{}]interface{}, map[interface{}]float64, interface{}) {
 	startNode := &Neighbor{
 		value: start,
 		cost:  0.0,
@@ -22,12 +45,20 @@ func AStarFindPath(start interface{}, coster NodeCoster, estimator NodeEstimator
 	costSoFar := make(map[interface{}]float64)
 	cameFrom[start] = nil
 	costSoFar[start] = 0
+	bestByKey := newBestCostIndex(options.Keyer)
+	bestByKey.improve(start, 0)
+	queued := 1
 
 	var final interface{}
 	for frontier.Len() > 0 {
 		currentI := heap.Pop(frontier)
 		current := currentI.(*Neighbor).value
 
+		// a cheaper path to the same point was found after this one was queued
+		if bestByKey.superseded(current, costSoFar[current]) {
+			continue
+		}
+
 		if isGoaler(current) {
 			final = current
 			break
@@ -38,14 +69,18 @@ func AStarFindPath(start interface{}, coster NodeCoster, estimator NodeEstimator
 		for _, node := range nGen(current) {
 			newCost := costSoFar[current] + coster(current, node)
 			existingNeighborCost, found := costSoFar[node]
-			if !found || newCost < existingNeighborCost {
+			if (!found || newCost < existingNeighborCost) && bestByKey.improve(node, newCost) {
 				costSoFar[node] = newCost
 				estimatedCost := estimator(node)
 				priority := newCost + estimatedCost
 				newNeighbor := &Neighbor{
-					value: node,
-					cost:  priority,
+					value:     node,
+					cost:      priority,
+					costSoFar: newCost,
+					rank:      rankOf(options.Ranker, current, node),
+					order:     queued,
 				}
+				queued++
 				//fmt.Printf(
 				//	"Adding neighbor %q with cost %f, estimate %f, priority %.3f\n",
 				//	node,
@@ -61,3 +96,10 @@ func AStarFindPath(start interface{}, coster NodeCoster, estimator NodeEstimator
 
 	return cameFrom, costSoFar, final
 }
+
+func rankOf(ranker NodeRanker, src, dst interface{}) int {
+	if ranker == nil {
+		return 0
+	}
+	return ranker(src, dst)
+}
@@ -0,0 +1,38 @@
+package planner
+
+// bestCostIndex remembers the cheapest cost found so far for each key, as
+// produced by a NodeKeyer.
+type bestCostIndex struct {
+	keyer NodeKeyer
+	costs map[interface{}]float64
+}
+
+func newBestCostIndex(keyer NodeKeyer) *bestCostIndex {
+	return &bestCostIndex{
+		keyer: keyer,
+		costs: make(map[interface{}]float64),
+	}
+}
+
+// improve records the cost of reaching a node, returning false if its key
+// has already been reached at the same or lower cost.
+func (bci *bestCostIndex) improve(n interface{}, cost float64) bool {
+	if bci.keyer == nil {
+		return true
+	}
+	key := bci.keyer(n)
+	if best, found := bci.costs[key]; found && best <= cost {
+		return false
+	}
+	bci.costs[key] = cost
+	return true
+}
+
+// superseded reports whether a cheaper path to the node's key has been
+// recorded since the node itself was.
+func (bci *bestCostIndex) superseded(n interface{}, cost float64) bool {
+	if bci.keyer == nil {
+		return false
+	}
+	return bci.costs[bci.keyer(n)] < cost
+}
@@ -5,6 +5,10 @@ import (
 )
 
 func DijkstraFindPath(start interface{}, coster NodeCoster, isGoal NodeIsGoaler, nGen NeighborGenerator) (map[interface{}]interface{}, map[interface{}]float64, interface{}) {
+	return DijkstraFindPathWithOptions(start, coster, isGoal, nGen, Options{})
+}
+
+func DijkstraFindPathWithOptions(start interface{}, coster NodeCoster, isGoal NodeIsGoaler, nGen NeighborGenerator, options Options) (map[interface{}]interface{}, map[interface{}]float64, interface{}) {
 	startNode := &Neighbor{
 		value: start,
 		cost:  0.0,
@@ -17,12 +21,19 @@ func DijkstraFindPath(start interface{}, coster NodeCoster, isGoal NodeIsGoaler,
 	costSoFar := make(map[interface{}]float64)
 	cameFrom[start] = nil
 	costSoFar[start] = 0
+	bestByKey := newBestCostIndex(options.Keyer)
+	bestByKey.improve(start, 0)
+	queued := 1
 
 	var final interface{}
 	for frontier.Len() > 0 {
 		currentI := heap.Pop(frontier)
 		current := currentI.(*Neighbor).value
 
+		if bestByKey.superseded(current, costSoFar[current]) {
+			continue
+		}
+
 		if isGoal(current) {
 			final = current
 			break
@@ -34,12 +45,16 @@ func DijkstraFindPath(start interface{}, coster NodeCoster, isGoal NodeIsGoaler,
 		for _, node := range neighbors {
 			newCost := costSoFar[current] + coster(current, node)
 			existingNeighborCost, found := costSoFar[node]
-			if !found || newCost < existingNeighborCost {
+			if (!found || newCost < existingNeighborCost) && bestByKey.improve(node, newCost) {
 				costSoFar[node] = newCost
 				newNeighbor := &Neighbor{
-					value: node,
-					cost:  newCost,
+					value:     node,
+					cost:      newCost,
+					costSoFar: newCost,
+					rank:      rankOf(options.Ranker, current, node),
+					order:     queued,
 				}
+				queued++
 				//fmt.Printf("added to frontier: %s\n", node)
 				heap.Push(frontier, newNeighbor)
 				cameFrom[node] = current
@@ -6,6 +6,11 @@ import "container/heap"
 type Neighbor struct {
 	value interface{} // The value of the item; arbitrary.
 	cost  float64     // The cost of the item in the queue.
+	// The cost of the path to the item so far, its rank, and the order in
+	// which it was queued break ties between items of equal cost.
+	costSoFar float64
+	rank      int
+	order     int
 	// The index is needed by update and is maintained by the heap.Interface methods.
 	index int // The index of the item in the heap.
 }
@@ -17,7 +22,18 @@ func (nq NeighborQueue) Len() int { return len(nq) }
 
 func (nq NeighborQueue) Less(i, j int) bool {
 	// We want Pop to give us the lowest, not highest, cost so we use lesser than here.
-	return nq[i].cost < nq[j].cost
+	if nq[i].cost != nq[j].cost {
+		return nq[i].cost < nq[j].cost
+	}
+	// among equally promising items, follow the path we're furthest along,
+	// rather than exploring every other way of getting there
+	if nq[i].costSoFar != nq[j].costSoFar {
+		return nq[i].costSoFar > nq[j].costSoFar
+	}
+	if nq[i].rank != nq[j].rank {
+		return nq[i].rank < nq[j].rank
+	}
+	return nq[i].order < nq[j].order
 }
 
 func (nq NeighborQueue) Swap(i, j int) {